@@ -0,0 +1,266 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"sort"
+
+	"github.com/dave/dst"
+)
+
+// Alignment reports the size, in bytes, of a struct type before and
+// after its fields were reordered
+type Alignment struct {
+	Filename string
+	Name     string
+	Before   int64
+	After    int64
+}
+
+// fieldGroup is a field declaration of a struct along with the
+// variables it declares.  Fields that declare several names are kept
+// together when reordering
+type fieldGroup struct {
+	field    *dst.Field
+	vars     []*types.Var
+	tags     []string
+	align    int64
+	size     int64
+	pointers bool
+}
+
+// aligner reorders the fields of struct types to minimise padding
+type aligner struct {
+	check    *checked
+	sizes    types.Sizes
+	excluded map[*types.Named]bool
+}
+
+// hasPointers determines if values of the type contain pointers
+func hasPointers(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Kind() == types.String || u.Kind() == types.UnsafePointer
+	case *types.Array:
+		return u.Len() > 0 && hasPointers(u.Elem())
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if hasPointers(u.Field(i).Type()) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// exclude marks the named struct types that make up t as ones whose
+// layout must not change
+func (a *aligner) exclude(t types.Type) {
+	for {
+		switch u := t.(type) {
+		case *types.Pointer:
+			t = u.Elem()
+			continue
+		case *types.Slice:
+			t = u.Elem()
+			continue
+		case *types.Array:
+			t = u.Elem()
+			continue
+		}
+		break
+	}
+
+	named, ok := t.(*types.Named)
+	if !ok || a.excluded[named] {
+		return
+	}
+
+	if st, ok := named.Underlying().(*types.Struct); ok {
+		a.excluded[named] = true
+		for i := 0; i < st.NumFields(); i++ {
+			a.exclude(st.Field(i).Type())
+		}
+	}
+}
+
+// analyze finds the struct types whose layout is relied upon.  These
+// are structs used in unkeyed composite literals, passed to the
+// encoding/binary package or declared in files that use cgo
+func (a *aligner) analyze() {
+	a.excluded = make(map[*types.Named]bool)
+	for _, file := range a.check.files {
+		cgo := false
+		for _, spec := range file.Imports {
+			cgo = cgo || spec.Path.Value == `"C"`
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.TypeSpec:
+				if cgo {
+					if tn, ok := a.check.info.Defs[n.Name].(*types.TypeName); ok {
+						a.exclude(tn.Type())
+					}
+				}
+			case *ast.CompositeLit:
+				// only the order of the literal's own fields is relied
+				// upon, not that of its elements or of its fields' types
+				named, ok := a.check.info.TypeOf(n).(*types.Named)
+				if !ok || len(n.Elts) == 0 {
+					break
+				}
+
+				if _, keyed := n.Elts[0].(*ast.KeyValueExpr); !keyed {
+					if _, ok := named.Underlying().(*types.Struct); ok {
+						a.excluded[named] = true
+					}
+				}
+			case *ast.CallExpr:
+				if sel, ok := unparen(n.Fun).(*ast.SelectorExpr); ok {
+					if fn, ok := a.check.info.Uses[sel.Sel].(*types.Func); ok && fn.Pkg() != nil && fn.Pkg().Path() == "encoding/binary" {
+						for _, arg := range n.Args {
+							if t := a.check.info.TypeOf(arg); t != nil {
+								a.exclude(t)
+							}
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// groups returns the field groups of the struct type declared by spec
+func (a *aligner) groups(spec *ast.TypeSpec, st *types.Struct) (groups []*fieldGroup) {
+	i := 0
+	for _, field := range spec.Type.(*ast.StructType).Fields.List {
+		g := &fieldGroup{field: a.check.nodes.Dst.Nodes[field].(*dst.Field)}
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+
+		for ; n > 0; n-- {
+			g.vars = append(g.vars, st.Field(i))
+			g.tags = append(g.tags, st.Tag(i))
+			i++
+		}
+
+		t := g.vars[0].Type()
+		g.align = a.sizes.Alignof(t)
+		g.size = a.sizes.Sizeof(t) * int64(len(g.vars))
+		g.pointers = hasPointers(t)
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// sizeof computes the size of a struct made up of the field groups
+func (a *aligner) sizeof(groups []*fieldGroup) int64 {
+	vars := []*types.Var{}
+	tags := []string{}
+	for _, g := range groups {
+		vars = append(vars, g.vars...)
+		tags = append(tags, g.tags...)
+	}
+	return a.sizes.Sizeof(types.NewStruct(vars, tags))
+}
+
+// align reorders the fields of the struct declared by spec.  Zero sized
+// fields come first, followed by fields in decreasing order of their
+// alignment with fields containing pointers before those that don't.
+// The fields are only reordered if doing so makes the struct smaller
+func (a *aligner) align(spec *ast.TypeSpec) (alignment Alignment, changed bool) {
+	if _, ok := spec.Type.(*ast.StructType); !ok || spec.Assign.IsValid() {
+		return alignment, false
+	}
+
+	tn, ok := a.check.info.Defs[spec.Name].(*types.TypeName)
+	if !ok {
+		return alignment, false
+	}
+
+	named, ok := tn.Type().(*types.Named)
+	if !ok || a.excluded[named] {
+		return alignment, false
+	}
+
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok || st.NumFields() == 0 {
+		return alignment, false
+	}
+
+	groups := a.groups(spec, st)
+	alignment = Alignment{
+		Name:   tn.Name(),
+		Before: a.sizes.Sizeof(st),
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		gi, gj := groups[i], groups[j]
+		if (gi.size == 0) != (gj.size == 0) {
+			return gi.size == 0
+		}
+
+		if gi.align != gj.align {
+			return gi.align > gj.align
+		}
+		return gi.pointers && !gj.pointers
+	})
+
+	alignment.After = a.sizeof(groups)
+	if alignment.After >= alignment.Before {
+		return alignment, false
+	}
+
+	fields := a.check.nodes.Dst.Nodes[spec.Type].(*dst.StructType).Fields
+	fields.List = fields.List[:0]
+	for _, g := range groups {
+		fields.List = append(fields.List, g.field)
+	}
+	return alignment, true
+}
+
+// AlignFields reorders the fields of the structs declared in the files,
+// or every file, to minimize their size on the SetArch architecture.
+// Structs whose layout is relied upon are left alone and the size of
+// each changed struct is returned
+func (f *Tools) AlignFields(files ...string) (alignments []Alignment, err error) {
+	defer f.operation()()
+	for _, filename := range files {
+		if _, found := f.dfiles[filename]; !found {
+			return nil, fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
+		}
+	}
+
+	if len(files) == 0 {
+		files = sortedFiles(f)
+	}
+
+	a := &aligner{check: f.edit(), sizes: f.sizes}
+	a.analyze()
+	for _, filename := range files {
+		start := f.print(filename)
+		for _, decl := range a.check.files[filename].Decls {
+			if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.TYPE {
+				for _, spec := range gd.Specs {
+					if alignment, changed := a.align(spec.(*ast.TypeSpec)); changed {
+						alignment.Filename = filename
+						alignments = append(alignments, alignment)
+					}
+				}
+			}
+		}
+
+		if _, err = f.record(filename, start); err != nil {
+			break
+		}
+	}
+	return alignments, err
+}
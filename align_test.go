@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestAlignFields(t *testing.T) {
+	input, err := ioutil.ReadFile("testdata/tools_test/AlignFields_01.input")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tools := New()
+	if err := tools.SetArch("amd64"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := tools.Add("foo.go", input); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got, err := tools.AlignFields()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	wantAlignments := []Alignment{
+		{Filename: "foo.go", Name: "Padded", Before: 24, After: 16},
+		{Filename: "foo.go", Name: "Grouped", Before: 24, After: 16},
+	}
+	if !reflect.DeepEqual(wantAlignments, got) {
+		t.Errorf("Wanted alignments %v got %v", wantAlignments, got)
+	}
+
+	if len(tools.Changes()) != 1 {
+		t.Errorf("Wanted 1 change got %d", len(tools.Changes()))
+	}
+
+	if err := tools.SetArch("pdp11"); err == nil {
+		t.Errorf("Wanted error for unknown architecture")
+	}
+}
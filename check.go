@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// checked is the go/types view of the files in a Tools session along
+// with the mapping between the checked ast nodes and the session's dst
+// nodes.  A checked value is only valid until the next modification of
+// the dst files it was built from
+type checked struct {
+	dfiles map[string]*dst.File
+	errs   []error
+	fset   *token.FileSet
+	files  map[string]*ast.File
+	info   *types.Info
+	pkg    *types.Package
+	nodes  decorator.Map
+}
+
+// object returns the object that the identifier defines or refers to,
+// or nil if the identifier is not known to the type checker
+func (c *checked) object(id *dst.Ident) types.Object {
+	if n, found := c.nodes.Ast.Nodes[id]; found {
+		if aid, ok := n.(*ast.Ident); ok {
+			if obj := c.info.Defs[aid]; obj != nil {
+				return obj
+			}
+			return c.info.Uses[aid]
+		}
+	}
+	return nil
+}
+
+// pos returns the position of the given dst node in the restored
+// file set, or token.NoPos if the node is not known
+func (c *checked) pos(n dst.Node) token.Pos {
+	if an, found := c.nodes.Ast.Nodes[n]; found {
+		return an.Pos()
+	}
+	return token.NoPos
+}
+
+// overlaps determines if the declaration, including its doc comment,
+// overlaps any of the line ranges
+func (c *checked) overlaps(decl dst.Decl, ranges []LineRange) bool {
+	n, found := c.nodes.Ast.Nodes[decl]
+	if !found {
+		return false
+	}
+
+	pos := n.Pos()
+	switch d := n.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			pos = d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			pos = d.Doc.Pos()
+		}
+	}
+
+	start, end := c.fset.Position(pos).Line, c.fset.Position(n.End()).Line
+	for _, r := range ranges {
+		if r.Start <= end && start <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// selection returns the selection information for the selector
+// expression, or nil if the expression is a qualified identifier
+func (c *checked) selection(sel *dst.SelectorExpr) *types.Selection {
+	if n, found := c.nodes.Ast.Nodes[sel]; found {
+		return c.info.Selections[n.(*ast.SelectorExpr)]
+	}
+	return nil
+}
+
+// qualifier qualifies objects from other packages by their package name
+func (c *checked) qualifier(pkg *types.Package) string {
+	if pkg == c.pkg {
+		return ""
+	}
+	return pkg.Name()
+}
+
+// typeExpr returns an expression that can be used to declare
+// a value of the given type within the checked package
+func (c *checked) typeExpr(t types.Type) dst.Expr {
+	return parseExpr(types.TypeString(t, c.qualifier))
+}
+
+// typeOf returns the type of the expression, or nil if the
+// type is not known
+func (c *checked) typeOf(expr dst.Expr) types.Type {
+	if n, found := c.nodes.Ast.Nodes[expr]; found {
+		if e, ok := n.(ast.Expr); ok {
+			return c.info.TypeOf(e)
+		}
+	}
+	return nil
+}
+
+// check type checks the files in the file set.  In order for positions
+// reported by the type checker to match the files' content, each file is
+// printed, re-parsed and re-decorated into the dfiles of the result; the
+// session's trees are left alone, see edit.  Type errors do not stop the
+// check and are collected in the errs field of the result so that
+// operations can work with packages that are mid-refactoring
+func (f *Tools) check() *checked {
+	dec := decorator.NewDecorator(token.NewFileSet())
+	c := &checked{
+		dfiles: make(map[string]*dst.File),
+		fset:   dec.Fset,
+		files:  make(map[string]*ast.File),
+		info: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Implicits:  make(map[ast.Node]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Scopes:     make(map[ast.Node]*types.Scope),
+		},
+		nodes: dec.Map,
+	}
+
+	files := []*ast.File{}
+	for _, filename := range sortedFiles(f) {
+		file, err := parser.ParseFile(c.fset, filename, f.print(filename), parser.ParseComments)
+		if err == nil {
+			var dfile *dst.File
+			dfile, err = dec.DecorateFile(file)
+			if err == nil {
+				c.dfiles[filename] = dfile
+				c.files[filename] = file
+				files = append(files, file)
+			}
+		}
+
+		if err != nil {
+			c.errs = append(c.errs, err)
+		}
+	}
+
+	path := f.path
+	if path == "" {
+		path = f.pkgname
+	}
+
+	conf := types.Config{
+		Importer: f.importer,
+		Error:    func(err error) { c.errs = append(c.errs, err) },
+		Sizes:    f.sizes,
+	}
+	c.pkg, _ = conf.Check(path, c.fset, files, c.info)
+	return c
+}
+
+// install replaces the session's trees with those decorated by the
+// check so that the nodes found through the check can be rewritten
+func (f *Tools) install(c *checked) {
+	for filename, dfile := range c.dfiles {
+		f.dfiles[filename] = dfile
+	}
+}
+
+// edit type checks the files and installs the checked trees, for
+// operations that rewrite the files
+func (f *Tools) edit() *checked {
+	c := f.check()
+	f.install(c)
+	return c
+}
+
+// enclosing returns the chain of nodes from root down to, and
+// including, n.  If n is not found under root then nil is returned
+func enclosing(root, n ast.Node) (path []ast.Node) {
+	stack := []ast.Node{}
+	ast.Inspect(root, func(c ast.Node) bool {
+		if path != nil {
+			return false
+		}
+
+		if c == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+
+		stack = append(stack, c)
+		if c == n {
+			path = append([]ast.Node{}, stack...)
+		}
+		return true
+	})
+	return path
+}
+
+// parseExpr parses a Go expression into a dst node.  The expression
+// is expected to be generated, so it is a programming error for it
+// not to parse
+func parseExpr(src string) dst.Expr {
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	n, err := decorator.Decorate(fset, expr)
+	if err != nil {
+		panic(err)
+	}
+	return n.(dst.Expr)
+}
+
+// zeroValue returns an expression for the zero value of the type
+func (c *checked) zeroValue(t types.Type) dst.Expr {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return dst.NewIdent("false")
+		case u.Info()&types.IsString != 0:
+			return &dst.BasicLit{Kind: token.STRING, Value: `""`}
+		case u.Info()&types.IsNumeric != 0:
+			return &dst.BasicLit{Kind: token.INT, Value: "0"}
+		}
+	case *types.Struct, *types.Array:
+		return &dst.CompositeLit{Type: c.typeExpr(t)}
+	}
+	return dst.NewIdent("nil")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// trimInterval is how often the cache is trimmed
+	trimInterval = 24 * time.Hour
+
+	// trimLimit is how long an entry may go unused before it is trimmed
+	trimLimit = 5 * 24 * time.Hour
+
+	// touchInterval is how stale an entry's modification time may be
+	// before a hit updates it
+	touchInterval = time.Hour
+)
+
+// resultCache records the files that gorg found to be organized.  An
+// entry is an empty file named by the hash of the tool, its
+// configuration, the file's name and the content of every file of the
+// file's package, since the result for a file depends on the rest of
+// its package
+type resultCache struct {
+	dir    string
+	prefix []byte
+}
+
+// openCache opens the cache in dir, or in the user's cache directory if
+// dir is empty, for the given configuration.  The cache is trimmed if it
+// has not been recently
+func openCache(dir, config string) (*resultCache, error) {
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(userDir, "gorg")
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "gorg %s\n%s\n", toolVersion(), config)
+	c := &resultCache{dir: dir, prefix: h.Sum(nil)}
+	c.trim(time.Now())
+	return c, nil
+}
+
+// toolVersion identifies the build of gorg by the hash of its
+// executable, falling back to the module version if the executable can
+// not be read
+func toolVersion() string {
+	if exe, err := os.Executable(); err == nil {
+		if f, err := os.Open(exe); err == nil {
+			defer f.Close()
+			h := sha256.New()
+			if _, err := io.Copy(h, f); err == nil {
+				return hex.EncodeToString(h.Sum(nil))
+			}
+		}
+	}
+
+	version := runtime.Version()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version += " " + info.Main.Version
+	}
+	return version
+}
+
+// keys returns the cache key of each of the files of a package, given
+// the content of every file of the package
+func (c *resultCache) keys(pkg map[string][]byte) map[string]string {
+	filenames := []string{}
+	for filename := range pkg {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	h := sha256.New()
+	h.Write(c.prefix)
+	for _, filename := range filenames {
+		sum := sha256.Sum256(pkg[filename])
+		fmt.Fprintf(h, "%s %x\n", filepath.Base(filename), sum)
+	}
+	pkgSum := h.Sum(nil)
+
+	keys := make(map[string]string)
+	for _, filename := range filenames {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%x %s", pkgSum, filepath.Base(filename))))
+		keys[filename] = hex.EncodeToString(sum[:])
+	}
+	return keys
+}
+
+// entry returns the name of the entry's file
+func (c *resultCache) entry(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// organized determines if the entry for key exists, updating its
+// modification time so that it is not trimmed while it is in use
+func (c *resultCache) organized(key string) bool {
+	name := c.entry(key)
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+
+	if now := time.Now(); now.Sub(fi.ModTime()) > touchInterval {
+		os.Chtimes(name, now, now)
+	}
+	return true
+}
+
+// mark records that the file with the key is organized
+func (c *resultCache) mark(key string) error {
+	name := c.entry(key)
+	err := os.MkdirAll(filepath.Dir(name), 0777)
+	if err == nil {
+		err = os.WriteFile(name, nil, 0666)
+	}
+	return err
+}
+
+// trim removes the entries that have not been used within trimLimit,
+// unless the cache was trimmed within trimInterval of now
+func (c *resultCache) trim(now time.Time) {
+	stamp := filepath.Join(c.dir, "trim.txt")
+	if data, err := os.ReadFile(stamp); err == nil {
+		if last, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && now.Sub(time.Unix(last, 0)) < trimInterval {
+			return
+		}
+	}
+
+	cutoff := now.Add(-trimLimit)
+	subdirs, _ := os.ReadDir(c.dir)
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(c.dir, subdir.Name())
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if fi, err := entry.Info(); err == nil && fi.ModTime().Before(cutoff) {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	os.WriteFile(stamp, []byte(strconv.FormatInt(now.Unix(), 10)), 0666)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tools "github.com/abates/gotools"
+)
+
+// git runs git, in the current directory, with the arguments and
+// returns its output.  Only commands that read the local repository are
+// run
+func git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-c", "core.quotePath=false"}, args...)...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitChanges returns the absolute names of the Go files that differ from
+// the merge base of base and HEAD, or from HEAD if base is empty, along
+// with the lines of each file that were added or changed.  If staged is
+// true only the changes staged in the index are returned and lines refer
+// to the staged content, otherwise they refer to the working tree
+func gitChanges(base string, staged bool) (map[string][]tools.LineRange, error) {
+	out, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top := strings.TrimSpace(string(out))
+
+	commit := "HEAD"
+	if base != "" {
+		if out, err = git("merge-base", base, "HEAD"); err != nil {
+			return nil, err
+		}
+		commit = strings.TrimSpace(string(out))
+	}
+
+	args := []string{"diff", "--name-only", "--diff-filter=ACMR", "-z"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	if out, err = git(append(args, commit, "--", ":(top)*.go")...); err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string][]tools.LineRange)
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			changes[filepath.Join(top, filepath.FromSlash(name))] = nil
+		}
+	}
+
+	args = []string{"diff", "-U0", "--no-color", "--no-ext-diff", "--no-prefix"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	if out, err = git(append(args, commit, "--", ":(top)*.go")...); err != nil {
+		return nil, err
+	}
+
+	filename := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "+++ ") {
+			filename = filepath.Join(top, filepath.FromSlash(strings.TrimPrefix(line, "+++ ")))
+		} else if _, found := changes[filename]; found && strings.HasPrefix(line, "@@ ") {
+			if r, ok := hunkRange(line); ok {
+				changes[filename] = append(changes[filename], r)
+			}
+		}
+	}
+	return changes, scanner.Err()
+}
+
+// hunkRange returns the lines of the new file covered by the hunk
+// header "@@ -a,b +c,d @@".  A hunk that only removes lines covers the
+// line before the removed ones, and the line after
+func hunkRange(header string) (tools.LineRange, bool) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return tools.LineRange{}, false
+	}
+
+	start, count := fields[2][1:], "1"
+	if i := strings.Index(start, ","); i >= 0 {
+		start, count = start[:i], start[i+1:]
+	}
+
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return tools.LineRange{}, false
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return tools.LineRange{}, false
+	}
+
+	if n == 0 {
+		return tools.LineRange{Start: s, End: s + 1}, true
+	}
+	return tools.LineRange{Start: s, End: s + n - 1}, true
+}
+
+// within determines if the file is one of the paths or is in one of the
+// directories, or their subdirectories, given by paths
+func within(filename string, paths []string) bool {
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err == nil {
+			abs, err = filepath.EvalSymlinks(abs)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if rel, err := filepath.Rel(abs, filename); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	tools "github.com/abates/gotools"
+)
+
+// TestMain runs gorg, instead of the tests, when the test binary is
+// started by runGorg
+func TestMain(m *testing.M) {
+	if os.Getenv("GORG_TEST_MAIN") != "" {
+		os.Args = append([]string{"gorg"}, strings.Fields(os.Getenv("GORG_TEST_MAIN"))...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runGorg runs gorg with the arguments in dir and returns its standard
+// error
+func runGorg(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GORG_TEST_MAIN="+strings.Join(args, " "))
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("gorg %s failed: %v: %s", strings.Join(args, " "), err, stderr)
+	}
+	return stderr.String()
+}
+
+// gitRepo creates a repository with the files committed and returns its
+// directory
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	writeFiles(t, dir, files)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=gorg", "-c", "user.email=gorg@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		runGit(t, dir, args...)
+	}
+	return dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestStagedChanges(t *testing.T) {
+	dir := gitRepo(t, map[string]string{
+		"a/a.go": "package a\n\nfunc b() {}\n\nfunc a() {}\n",
+		"b/b.go": "package b\n\nfunc b() {}\n\nfunc a() {}\n",
+	})
+
+	// staged changes to line 5 of both packages
+	writeFiles(t, dir, map[string]string{
+		"a/a.go": "package a\n\nfunc b() {}\n\nfunc a() { println() }\n",
+		"b/b.go": "package b\n\nfunc b() {}\n\nfunc a() { println() }\n",
+	})
+	runGit(t, dir, "add", "-A")
+
+	// an unstaged change to line 3
+	writeFiles(t, dir, map[string]string{
+		"a/a.go": "package a\n\nfunc b() { println() }\n\nfunc a() { println() }\n",
+	})
+
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+	changes, err := gitChanges("", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := map[string][]tools.LineRange{
+		filepath.Join(dir, "a", "a.go"): {{Start: 5, End: 5}},
+		filepath.Join(dir, "b", "b.go"): {{Start: 5, End: 5}},
+	}
+	if !reflect.DeepEqual(want, changes) {
+		t.Errorf("Wanted %v got %v", want, changes)
+	}
+
+	got := runGorg(t, dir, "-staged", "-lines", "-cache=off", "-l")
+	if want := "a/a.go\nb/b.go\n"; got != want {
+		t.Errorf("Wanted %q got %q", want, got)
+	}
+}
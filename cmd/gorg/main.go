@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"go/build"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 
 	tools "github.com/abates/gotools"
@@ -16,77 +19,440 @@ var (
 	list   = flag.Bool("l", false, "list files whose formatting differs from gofmt's")
 	write  = flag.Bool("w", false, "write result to (source) file instead of stdout")
 	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+
+	arch     = flag.String("arch", build.Default.GOARCH, "architecture used to compute struct sizes")
+	deadcode = flag.Bool("deadcode", false, "remove unused unexported declarations before organizing")
+	local    = flag.String("local", "", "put imports beginning with this string after 3rd-party packages")
+	named    = flag.String("named", "group", "placement of named imports: group, end or section")
+	blank    = flag.String("blank", "group", "placement of blank imports: group, end or section")
+	order    = flag.String("sortvalues", "", "sort the specs of value blocks by name or value")
+	verify   = flag.String("verify", "", "check the changes before writing: api or bodies")
+	split    = flag.Bool("splitnames", false, "split value specs that declare several names into a spec per name")
+	jobs     = flag.Int("j", runtime.GOMAXPROCS(0), "maximum number of files to parse or organize at once")
+
+	backup      = flag.Bool("backup", false, "keep the original of each rewritten file in a .orig file")
+	overlayFile = flag.String("overlay", "", "read file contents from the replacements listed in a JSON overlay file")
+	cacheDir    = flag.String("cache", "", "directory of the cache of organized files, the user cache directory if empty, or off")
+
+	// git-aware mode
+	base   = flag.String("base", "", "only process Go files changed since the merge base of this git ref and HEAD")
+	staged = flag.Bool("staged", false, "only process Go files with changes staged in the git index")
+	lines  = flag.Bool("lines", false, "only organize declarations that overlap lines changed in git, with -base or -staged")
 )
 
+// lineRanges are the changed lines of each file when organizing is restricted to them by the -lines flag
+var lineRanges map[string][]tools.LineRange
+
+// uncachedFlags are the flags that do not change the result of a command
+var uncachedFlags = map[string]bool{
+	"l": true, "w": true, "d": true, "j": true,
+	"backup": true, "cache": true, "overlay": true,
+	"base": true, "staged": true,
+}
+
+// overlaid is the content of the files replaced by the -overlay flag,
+// keyed by their absolute names
+var overlaid = map[string][]byte{}
+
+// readOverlay reads a JSON overlay file, in the format accepted by the
+// go command's -overlay flag, and returns the replaced files' contents
+func readOverlay(filename string) (map[string][]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := struct{ Replace map[string]string }{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte)
+	for name, replacement := range config.Replace {
+		if contents[name], err = os.ReadFile(replacement); err != nil {
+			return nil, err
+		}
+
+		if abs, err := filepath.Abs(name); err == nil {
+			overlaid[abs] = contents[name]
+		}
+	}
+	return contents, nil
+}
+
+// inOverlay determines if the file is one of the overlay's files
+func inOverlay(filename string) bool {
+	abs, err := filepath.Abs(filename)
+	_, found := overlaid[abs]
+	return err == nil && found
+}
+
+// packageSources returns the content of the *.go files in dir, including
+// those that are only in the overlay, keyed by their base names
+func packageSources(dir string) (map[string][]byte, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string][]byte)
+	for _, filename := range filenames {
+		if sources[filepath.Base(filename)], err = os.ReadFile(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	abs, err := filepath.Abs(dir)
+	for filename, content := range overlaid {
+		if err == nil && filepath.Dir(filename) == abs && filepath.Ext(filename) == ".go" {
+			sources[filepath.Base(filename)] = content
+		}
+	}
+	return sources, nil
+}
+
+// configuration describes the command and the flags that affect its
+// result
+func configuration(command string) string {
+	config := []string{command}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !uncachedFlags[f.Name] {
+			config = append(config, f.Name+"="+f.Value.String())
+		}
+	})
+	return strings.Join(config, " ")
+}
+
+// cached determines if each of the targets, or every file if targets is
+// empty, of the package in dir is known to be organized
+func cached(cache *resultCache, dir string, targets []string) bool {
+	sources, err := packageSources(dir)
+	if err != nil || len(sources) == 0 {
+		return false
+	}
+
+	keys := cache.keys(sources)
+	if len(targets) == 0 {
+		for name := range keys {
+			targets = append(targets, name)
+		}
+	}
+
+	for _, target := range targets {
+		key, found := keys[filepath.Base(target)]
+		if !found || !cache.organized(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// markOrganized records the targets of the package in dir, or every
+// file if targets is empty, whose content on disk is organized
+func markOrganized(cache *resultCache, dir string, targets []string, changed map[string]bool) {
+	sources, err := packageSources(dir)
+	if err != nil {
+		return
+	}
+
+	keys := cache.keys(sources)
+	if len(targets) == 0 {
+		for name := range keys {
+			targets = append(targets, filepath.Join(dir, name))
+		}
+	}
+
+	for _, target := range targets {
+		if key, found := keys[filepath.Base(target)]; found && !changed[filepath.Clean(target)] {
+			cache.mark(key)
+		}
+	}
+}
+
+// placements are the values of the -named and -blank flags
+var placements = map[string]tools.ImportPlacement{
+	"group":   tools.ImportInGroup,
+	"end":     tools.ImportGroupEnd,
+	"section": tools.ImportSection,
+}
+
+// orders are the values of the -sortvalues flag
+var orders = map[string]tools.ValueOrder{
+	"":      tools.OrderDeclared,
+	"name":  tools.OrderByName,
+	"value": tools.OrderByValue,
+}
+
+// verifications are the values of the -verify flag
+var verifications = map[string]tools.Verification{
+	"":       tools.VerifyNone,
+	"api":    tools.VerifyAPI,
+	"bodies": tools.VerifyBodies,
+}
+
+// commands are the operations gorg can perform on the files, without
+// a command the files are organized
+var commands = map[string]func(*tools.Tools, []string) error{
+	"align":    align,
+	"deadcode": removeDeadCode,
+	"merge":    mergeValues,
+	"organize": organize,
+}
+
+func align(t *tools.Tools, files []string) error {
+	err := t.SetArch(*arch)
+	if err == nil {
+		var alignments []tools.Alignment
+		alignments, err = t.AlignFields(files...)
+		for _, a := range alignments {
+			fmt.Fprintf(os.Stderr, "%s: struct %s %d -> %d bytes\n", a.Filename, a.Name, a.Before, a.After)
+		}
+	}
+	return err
+}
+
+func removeDeadCode(t *tools.Tools, files []string) error {
+	unused, err := t.DeadCode(true)
+	for _, u := range unused {
+		fmt.Fprintf(os.Stderr, "%s: unused %s %s\n", u.Position, u.Kind, u.Name)
+	}
+	return err
+}
+
+func mergeValues(t *tools.Tools, files []string) (err error) {
+	for _, filename := range files {
+		if _, err = t.MergeValues(filename); err != nil {
+			break
+		}
+	}
+	return err
+}
+
+func organize(t *tools.Tools, files []string) (err error) {
+	if *deadcode {
+		err = removeDeadCode(t, files)
+	}
+
+	if err == nil && lineRanges != nil {
+		for _, filename := range files {
+			if _, err = t.OrganizeLines(filename, lineRanges[filename]...); err != nil {
+				break
+			}
+		}
+	} else if err == nil {
+		err = t.OrganizeFiles(files...)
+	}
+	return err
+}
+
 func main() {
 	flag.Usage = usage
-	flag.Parse()
+	command := "organize"
+	if len(os.Args) > 1 && commands[os.Args[1]] != nil {
+		command = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	args := flag.Args()
+	if *lines && *base == "" && !*staged {
+		fmt.Fprintf(os.Stderr, "The -lines flag requires -base or -staged\n")
+		os.Exit(-1)
+	}
+
+	if *base != "" || *staged {
+		changes, err := gitChanges(*base, *staged)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read git changes: %v\n", err)
+			os.Exit(-1)
+		}
+
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+
+		wd, _ := os.Getwd()
+		wd, _ = filepath.EvalSymlinks(wd)
+		changed := []string{}
+		ranges := map[string][]tools.LineRange{}
+		for filename, r := range changes {
+			if within(filename, args) {
+				if rel, err := filepath.Rel(wd, filename); err == nil {
+					filename = rel
+				}
+				changed = append(changed, filename)
+				ranges[filename] = r
+			}
+		}
+
+		if len(changed) == 0 {
+			return
+		}
+		sort.Strings(changed)
+		args = changed
+
+		if *lines {
+			lineRanges = ranges
+		}
+	}
+
 	if len(args) == 0 {
 		usage()
 		return
 	}
 
-	added := map[string]bool{}
-	files := []string{}
-	tools := tools.New()
+	rules := tools.ImportRules{LocalPrefix: *local}
+	var found, blankFound bool
+	rules.Named, found = placements[*named]
+	rules.Blank, blankFound = placements[*blank]
+	if !found || !blankFound {
+		fmt.Fprintf(os.Stderr, "Unknown import placement %q or %q\n", *named, *blank)
+		os.Exit(-1)
+	}
+
+	valueOrder, found := orders[*order]
+	if !found {
+		fmt.Fprintf(os.Stderr, "Unknown value order %q\n", *order)
+		os.Exit(-1)
+	}
+
+	verification, found := verifications[*verify]
+	if !found {
+		fmt.Fprintf(os.Stderr, "Unknown verification %q\n", *verify)
+		os.Exit(-1)
+	}
+
+	var overlay map[string][]byte
+	if *overlayFile != "" {
+		var err error
+		if overlay, err = readOverlay(*overlayFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read overlay %q: %v\n", *overlayFile, err)
+			os.Exit(-1)
+		}
+	}
 
+	// each package is processed in a session of its own
+	newSession := func() *tools.Tools {
+		session := tools.New()
+		session.SetConcurrency(*jobs)
+		session.SetImportRules(rules)
+		session.SetSplitNames(*split)
+		session.SetValueOrder(valueOrder)
+		session.SetVerification(verification)
+		if overlay != nil {
+			session.SetOverlay(overlay)
+		}
+		return session
+	}
+
+	var cache *resultCache
+	if command == "organize" && *cacheDir != "off" && lineRanges == nil {
+		var err error
+		if cache, err = openCache(*cacheDir, configuration(command)); err != nil {
+			fmt.Fprintf(os.Stderr, "Not using the cache: %v\n", err)
+		}
+	}
+
+	// packages are the directories of the files in the order they were
+	// given, with the files given in each or none if the whole
+	// directory was given
+	packages := []string{}
+	targets := map[string][]string{}
 	for _, arg := range args {
-		if fi, err := os.Stat(arg); err == nil {
-			if fi.IsDir() {
-				f, _ := filepath.Glob(filepath.Join(arg, "*.go"))
-				files = append(files, f...)
-			} else {
-				files = append(files, arg)
-				arg = filepath.Dir(arg)
-			}
+		fi, err := os.Stat(arg)
+		if err != nil && !inOverlay(arg) {
+			fmt.Fprintf(os.Stderr, "Failed to stat %s: %v\n", arg, err)
+			continue
+		}
 
-			if _, found := added[arg]; !found {
-				err = tools.AddDir(arg)
-				added[arg] = true
-			}
+		dir := filepath.Clean(arg)
+		if err != nil || !fi.IsDir() {
+			dir = filepath.Dir(arg)
+		}
 
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to add %q: %v\n", arg, err)
-				os.Exit(-1)
-			}
+		if _, found := targets[dir]; !found {
+			packages = append(packages, dir)
+			targets[dir] = []string{}
+		}
+
+		if dir != filepath.Clean(arg) && targets[dir] != nil {
+			targets[dir] = append(targets[dir], arg)
 		} else {
-			fmt.Fprintf(os.Stderr, "Failed to stat %s: %v\n", arg, err)
+			// the whole directory is a target
+			targets[dir] = nil
 		}
 	}
 
-	err := tools.OrganizeFiles(files...)
-	if err == nil {
-		if *list {
-			fmt.Fprintln(os.Stderr, strings.Join(tools.ChangedFiles(), "\n"))
-		} else if *doDiff {
-			for _, change := range tools.Changes() {
-				if d, err := diff("", change.Orig, change.Current); err != nil {
-					fmt.Fprintf(os.Stderr, "Couldn't perform diff on %s: %v", change.Filename, err)
-				} else if len(d) > 0 {
-					fmt.Fprintf(os.Stdout, "%s\n%s", change.Filename, string(d))
+	for _, dir := range packages {
+		if cache != nil && cached(cache, dir, targets[dir]) {
+			continue
+		}
+
+		session := newSession()
+		if err := session.AddDir(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add %q: %v\n", dir, err)
+			os.Exit(-1)
+		}
+
+		files := targets[dir]
+		if files == nil {
+			files = session.Files()
+		}
+
+		if err := run(command, session, files); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to %s: %v", command, err)
+			os.Exit(-1)
+		}
+
+		if cache != nil {
+			changed := map[string]bool{}
+			for _, filename := range session.ChangedFiles() {
+				if !*write || inOverlay(filename) {
+					changed[filepath.Clean(filename)] = true
 				}
 			}
-		} else if *write {
-			wf := func(name string, content []byte) error {
-				return ioutil.WriteFile(name, content, 0)
-			}
-			err = tools.WriteFiles(wf)
-		} else {
-			for _, change := range tools.Changes() {
-				fmt.Printf("%s\n", string(change.Current))
-			}
+			markOrganized(cache, dir, targets[dir], changed)
 		}
 	}
+}
 
+// run performs the command on the files of the session and then lists,
+// diffs, writes or prints the changed files as the flags select
+func run(command string, session *tools.Tools, files []string) error {
+	err := commands[command](session, files)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to organize: %v", err)
-		os.Exit(-1)
+		return err
+	}
+
+	if *list {
+		if changed := session.ChangedFiles(); len(changed) > 0 {
+			fmt.Fprintln(os.Stderr, strings.Join(changed, "\n"))
+		}
+	} else if *doDiff {
+		for _, change := range session.Changes() {
+			if d, err := diff("", change.Orig, change.Current); err != nil {
+				fmt.Fprintf(os.Stderr, "Couldn't perform diff on %s: %v", change.Filename, err)
+			} else if len(d) > 0 {
+				fmt.Fprintf(os.Stdout, "%s\n%s", change.Filename, string(d))
+			}
+		}
+	} else if *write {
+		err = session.WriteFilesAtomic(*backup)
+	} else {
+		for _, change := range session.Changes() {
+			fmt.Printf("%s\n", string(change.Current))
+		}
 	}
+	return err
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: gofmt [flags] [path ...]\n")
+	fmt.Fprintf(os.Stderr, "usage: gorg [command] [flags] [path ...]\n")
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	fmt.Fprintf(os.Stderr, "  align     reorder struct fields to minimise padding\n")
+	fmt.Fprintf(os.Stderr, "  deadcode  remove unused unexported declarations\n")
+	fmt.Fprintf(os.Stderr, "  merge     merge adjacent const and var declarations into blocks\n")
+	fmt.Fprintf(os.Stderr, "  organize  organize declarations (default)\n\n")
 	flag.PrintDefaults()
 }
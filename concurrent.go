@@ -0,0 +1,160 @@
+package tools
+
+import (
+	"bytes"
+	"go/format"
+	"path/filepath"
+	"sync"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// parallel calls fn with each index from 0 to count-1 using at most
+// workers goroutines and returns once every call has returned
+func parallel(workers, count int, fn func(i int)) {
+	if workers > count {
+		workers = count
+	}
+
+	if workers <= 1 {
+		for i := 0; i < count; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// render fixes the imports of the file and returns its formatted source.
+// If the source can not be formatted it is returned as printed along
+// with the error
+func (f *Tools) render(filename string, file *dst.File, declared map[string]bool) ([]byte, error) {
+	fixImports(file, filepath.Dir(filename), declared, f.imports.LocalPrefix, f.index)
+
+	output := &bytes.Buffer{}
+	decorator.Fprint(output, file)
+	end, err := format.Source(output.Bytes())
+	if err != nil {
+		end = output.Bytes()
+	}
+	return end, err
+}
+
+// organized is the result of organizing a file on one of the workers
+type organized struct {
+	start []byte
+	file  *dst.File
+	end   []byte
+	err   error
+}
+
+// organizeFile separates the value blocks of the file, using the shared
+// type check, and organizes it in the same steps that Organize does.
+// The file must not be used by any other goroutine
+func (f *Tools) organizeFile(filename string, file *dst.File, check *checked, declared map[string]bool) (result organized) {
+	buf := &bytes.Buffer{}
+	decorator.Fprint(buf, file)
+	result.start = buf.Bytes()
+
+	vc := &valueCleaner{
+		file:       file,
+		check:      check,
+		order:      f.order,
+		splitNames: f.split,
+	}
+	file = vc.separateValDecls()
+	if _, result.err = f.render(filename, file, declared); result.err != nil {
+		return result
+	}
+
+	o := organizer{
+		file:    file,
+		imports: f.imports,
+	}
+	result.file = o.organize()
+	result.end, result.err = f.render(filename, result.file, declared)
+	return result
+}
+
+// organizeConcurrently organizes the files on a pool of workers.  The
+// package is type checked once, rather than once for each file, and the
+// values of its constants are compared once every file is organized.
+// It returns false, having left the files unchanged, if the results may
+// differ from organizing the files one at a time, in which case the
+// caller must do so
+func (f *Tools) organizeConcurrently(files []string) bool {
+	var check *checked
+	seen := make(map[string]bool)
+	for _, filename := range files {
+		dfile, found := f.dfiles[filename]
+		if !found || seen[filename] {
+			return false
+		}
+		seen[filename] = true
+
+		if check == nil && hasValueBlocks(dfile) {
+			check = f.edit()
+		}
+	}
+
+	all := []*dst.File{}
+	for _, file := range f.dfiles {
+		all = append(all, file)
+	}
+	declared := declaredNames(all...)
+
+	dfiles := make([]*dst.File, len(files))
+	for i, filename := range files {
+		dfiles[i] = f.dfiles[filename]
+	}
+
+	results := make([]organized, len(files))
+	parallel(f.concurrency, len(files), func(i int) {
+		results[i] = f.organizeFile(files[i], dfiles[i], check, declared)
+	})
+
+	ok := true
+	for i, filename := range files {
+		ok = ok && results[i].err == nil
+		if results[i].file != nil {
+			f.dfiles[filename] = results[i].file
+		}
+	}
+
+	if ok && check != nil {
+		after := f.check()
+		for _, filename := range files {
+			consts := after.constants(filename)
+			for name, c := range check.constants(filename) {
+				ok = ok && sameConstant(c, consts[name])
+			}
+		}
+	}
+
+	for i, filename := range files {
+		if ok {
+			f.recordChange(filename, results[i].start, results[i].end)
+		} else {
+			f.parse(filename, results[i].start)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParallel(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 100} {
+		counts := make([]int, 10)
+		parallel(workers, len(counts), func(i int) { counts[i]++ })
+		for i, count := range counts {
+			if count != 1 {
+				t.Errorf("workers %d: wanted index %d called once got %d", workers, i, count)
+			}
+		}
+	}
+}
+
+// packageSession adds every input of the organizing tests that is in
+// package foo to a session
+func packageSession(t *testing.T, concurrency int) *Tools {
+	t.Helper()
+	inputs := []string{}
+	for _, pattern := range []string{"MergeValues_*", "Organize_*", "SeparateValues_*"} {
+		matches, _ := filepath.Glob(filepath.Join("testdata/tools_test", pattern+".input"))
+		inputs = append(inputs, matches...)
+	}
+	session := New()
+	session.SetConcurrency(concurrency)
+	for _, input := range inputs {
+		src, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatalf("Failed to read file %s: %v", input, err)
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), input, src, parser.PackageClauseOnly)
+		if err == nil && file.Name.Name == "foo" {
+			if err := session.Add(filepath.Base(input)+".go", src); err != nil {
+				t.Fatalf("Failed to add %s: %v", input, err)
+			}
+		}
+	}
+	return session
+}
+
+func TestOrganizeConcurrently(t *testing.T) {
+	sequential := packageSession(t, 1)
+	if err := sequential.OrganizeAll(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	concurrent := packageSession(t, 8)
+	if err := concurrent.OrganizeAll(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want, got := sequential.Changes(), concurrent.Changes()
+	if len(want) == 0 || len(want) != len(got) {
+		t.Fatalf("Wanted %d changes got %d", len(want), len(got))
+	}
+
+	for i := range want {
+		if want[i].Filename != got[i].Filename || !bytes.Equal(want[i].Orig, got[i].Orig) || !bytes.Equal(want[i].Current, got[i].Current) {
+			t.Errorf("%s: wanted:\n%s\ngot:\n%s", want[i].Filename, want[i].Current, got[i].Current)
+		}
+	}
+
+	if err := concurrent.Undo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if changes := concurrent.Changes(); len(changes) != 0 {
+		t.Errorf("Wanted no changes after undo got %d", len(changes))
+	}
+}
+
+func TestAddFilesConcurrently(t *testing.T) {
+	files := []string{
+		"testdata/write_files_test/input/foo.go",
+		"testdata/write_files_test/missing.go",
+		"testdata/write_files_test/input/main.go",
+	}
+
+	session := New()
+	session.SetConcurrency(4)
+	err := session.AddFiles(files...)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Wanted %v got %v", fs.ErrNotExist, err)
+	}
+
+	if got := strings.Join(session.Files(), " "); got != files[0] {
+		t.Errorf("Wanted only %s to be added got %s", files[0], got)
+	}
+}
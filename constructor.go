@@ -0,0 +1,326 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// structField is a field of a struct type that a constructor or an
+// option sets
+type structField struct {
+	name  string
+	param string
+	typ   string
+	doc   []string
+}
+
+// constructor generates constructors and functional options from the
+// fields of a struct type
+type constructor struct {
+	check    *checked
+	named    *types.Named
+	filename string
+	fields   []*structField
+}
+
+// newConstructor looks up the struct type and the fields that the
+// generated code should set.  If no names are given every field of
+// the struct is used
+func (f *Tools) newConstructor(typ string, names []string) (*constructor, error) {
+	c := &constructor{check: f.edit()}
+	obj, ok := c.check.pkg.Scope().Lookup(typ).(*types.TypeName)
+	if ok {
+		c.named, ok = obj.Type().(*types.Named)
+	}
+
+	var spec *ast.TypeSpec
+	if ok {
+		c.filename = c.check.fset.File(obj.Pos()).Name()
+		ast.Inspect(c.check.files[c.filename], func(n ast.Node) bool {
+			if ts, ok := n.(*ast.TypeSpec); ok && c.check.info.Defs[ts.Name] == obj {
+				spec = ts
+			}
+			return spec == nil
+		})
+	}
+
+	if spec == nil {
+		return nil, fmt.Errorf("%w: type %s", ErrDeclNotFound, typ)
+	}
+
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a struct", ErrUnsupported, typ)
+	}
+
+	all := make(map[string]*structField)
+	for _, field := range st.Fields.List {
+		dfield := c.check.nodes.Dst.Nodes[field].(*dst.Field)
+		doc := append([]string{}, dfield.Decs.Start...)
+
+		fieldNames := []string{}
+		for _, name := range field.Names {
+			fieldNames = append(fieldNames, name.Name)
+		}
+
+		if len(field.Names) == 0 {
+			// the name of an embedded field is its type name
+			fieldNames = append(fieldNames, embeddedName(dfield.Type))
+		}
+
+		for _, name := range fieldNames {
+			if name == "_" {
+				continue
+			}
+			if name == "" {
+				return nil, fmt.Errorf("%w: embedded field %s has no name", ErrUnsupported, exprString(dfield.Type))
+			}
+
+			sf := &structField{name: name, param: paramName(name), typ: exprString(dfield.Type), doc: doc}
+			all[name] = sf
+			if len(names) == 0 {
+				c.fields = append(c.fields, sf)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if all[name] == nil {
+			return nil, fmt.Errorf("%w: field %s.%s", ErrDeclNotFound, typ, name)
+		}
+		c.fields = append(c.fields, all[name])
+	}
+	return c, nil
+}
+
+// embeddedName returns the name of an embedded field, which is the name
+// of its type without the package or type arguments
+func embeddedName(typ dst.Expr) string {
+	switch t := typ.(type) {
+	case *dst.StarExpr:
+		return embeddedName(t.X)
+	case *dst.Ident:
+		return t.Name
+	case *dst.SelectorExpr:
+		return t.Sel.Name
+	case *dst.IndexExpr:
+		return embeddedName(t.X)
+	}
+	return ""
+}
+
+// paramName returns the name of the parameter used to set the field, or
+// an empty string for a field without a name
+func paramName(field string) string {
+	if field == "" {
+		return ""
+	}
+	runes := []rune(field)
+	runes[0] = unicode.ToLower(runes[0])
+	name := string(runes)
+	if token.IsKeyword(name) || types.Universe.Lookup(name) != nil {
+		name += "Value"
+	}
+	return name
+}
+
+// optionName returns the name of the function that sets the field, or
+// an empty string for a field without a name
+func optionName(field string) string {
+	if field == "" {
+		return ""
+	}
+	runes := []rune(field)
+	runes[0] = unicode.ToUpper(runes[0])
+	return "With" + string(runes)
+}
+
+// optionDoc returns the first line of the doc comment generated for an
+// option, which is also used to recognise previously generated options
+func (c *constructor) optionDoc(field string) string {
+	return fmt.Sprintf("// %s sets the %s field of %s", optionName(field), field, c.named.Obj().Name())
+}
+
+// constructorSource generates a constructor that takes every field as a
+// parameter
+func (c *constructor) constructorSource() []byte {
+	typ := c.named.Obj().Name()
+	buf := &bytes.Buffer{}
+	params := []string{}
+	for i, field := range c.fields {
+		if i+1 < len(c.fields) && c.fields[i+1].typ == field.typ {
+			params = append(params, field.param)
+		} else {
+			params = append(params, field.param+" "+field.typ)
+		}
+	}
+
+	fmt.Fprintf(buf, "// New%s creates a %s\n", typ, typ)
+	fmt.Fprintf(buf, "func New%s(%s) *%s {\n\treturn &%s{\n", typ, strings.Join(params, ", "), typ, typ)
+	for _, field := range c.fields {
+		fmt.Fprintf(buf, "\t\t%s: %s,\n", field.name, field.param)
+	}
+	fmt.Fprintf(buf, "\t}\n}\n")
+	return buf.Bytes()
+}
+
+// optionsSource generates a constructor that accepts functional
+// options along with an option for each field
+func (c *constructor) optionsSource() []byte {
+	typ := c.named.Obj().Name()
+	recv := receiverName(c.named)
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "// New%s creates a %s configured by the options\n", typ, typ)
+	fmt.Fprintf(buf, "func New%s(options ...Option) *%s {\n", typ, typ)
+	fmt.Fprintf(buf, "\t%s := &%s{}\n\tfor _, option := range options {\n\t\toption(%s)\n\t}\n\treturn %s\n}\n\n", recv, typ, recv, recv)
+
+	for _, field := range c.fields {
+		fmt.Fprintf(buf, "%s\n", c.optionDoc(field.name))
+		if len(field.doc) > 0 {
+			fmt.Fprintf(buf, "//\n%s\n", strings.Join(field.doc, "\n"))
+		}
+		param := field.param
+		if param == recv {
+			// the option's receiver would shadow the parameter
+			param += "Value"
+		}
+
+		fmt.Fprintf(buf, "func %s(%s %s) Option {\n", optionName(field.name), param, field.typ)
+		fmt.Fprintf(buf, "\treturn func(%s *%s) {\n\t\t%s.%s = %s\n\t}\n}\n\n", recv, typ, recv, field.name, param)
+	}
+	return buf.Bytes()
+}
+
+// parse parses the generated source, refusing to replace a function of
+// the package that wasn't generated by a previous run
+func (c *constructor) parse(f *Tools, src []byte) (*dst.File, error) {
+	file, err := decorator.Parse(fmt.Sprintf("package %s\n\n%s", f.pkgname, src))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*dst.FuncDecl)
+		if !ok {
+			continue
+		}
+		for _, existing := range f.dfiles {
+			for _, decl := range existing.Decls {
+				if old, ok := decl.(*dst.FuncDecl); ok && old.Recv == nil && old.Name.Name == fn.Name.Name {
+					if len(old.Decs.Start) == 0 || !c.generated(fn.Name.Name, old.Decs.Start[0]) {
+						return nil, fmt.Errorf("%w: %s was not generated", ErrDeclExists, fn.Name.Name)
+					}
+				}
+			}
+		}
+	}
+	return file, nil
+}
+
+// generated reports whether doc is the first line of the doc comment
+// of a constructor or option generated for the type
+func (c *constructor) generated(name, doc string) bool {
+	typ := c.named.Obj().Name()
+	if name == "New"+typ {
+		return doc == fmt.Sprintf("// New%s creates a %s", typ, typ) || doc == fmt.Sprintf("// New%s creates a %s configured by the options", typ, typ)
+	}
+	return strings.HasPrefix(doc, "// "+name+" sets the ") && strings.HasSuffix(doc, fmt.Sprintf(" field of %s", typ))
+}
+
+// generate upserts the functions declared by the generated file into the
+// type's file
+func (f *Tools) generate(filename string, file *dst.File) {
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok {
+			f.upsert(filename, fn)
+		} else {
+			o := &organizer{file: f.dfiles[filename]}
+			o.insert(decl)
+		}
+	}
+}
+
+// GenerateConstructor adds a NewType function that creates the struct
+// type with the given fields, or every field if none are given, set from
+// its parameters.  The constructor is placed with the type, as Organize
+// would place it, and a constructor from a previous run is replaced.  A
+// hand-written constructor is left alone and ErrDeclExists returned
+func (f *Tools) GenerateConstructor(typ string, fields ...string) error {
+	defer f.operation()()
+	c, err := f.newConstructor(typ, fields)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.parse(f, c.constructorSource())
+	if err != nil {
+		return err
+	}
+
+	starts := f.snapshot()
+	f.generate(c.filename, file)
+	return f.recordAll(starts)
+}
+
+// GenerateOptions adds an Option type, a WithField option for each of the
+// fields, or every field, and a NewType constructor that applies them.
+// Options from a previous run are replaced or, for removed fields, deleted,
+// while hand-written functions of the same names cause ErrDeclExists
+func (f *Tools) GenerateOptions(typ string, fields ...string) error {
+	defer f.operation()()
+	c, err := f.newConstructor(typ, fields)
+	if err != nil {
+		return err
+	}
+
+	src := c.optionsSource()
+	option := c.check.pkg.Scope().Lookup("Option")
+	if option == nil {
+		src = append([]byte(fmt.Sprintf("// Option configures a %s\ntype Option func(*%s)\n\n", typ, typ)), src...)
+	} else if sig, ok := option.Type().Underlying().(*types.Signature); !ok || sig.Params().Len() != 1 || !types.Identical(sig.Params().At(0).Type(), types.NewPointer(c.named)) {
+		return fmt.Errorf("%q: %w", "Option", ErrDeclExists)
+	}
+
+	file, err := c.parse(f, src)
+	if err != nil {
+		return err
+	}
+
+	starts := f.snapshot()
+	c.removeStale(f)
+	f.generate(c.filename, file)
+	return f.recordAll(starts)
+}
+
+// removeStale deletes the generated options for fields of the type that
+// no longer exist
+func (c *constructor) removeStale(f *Tools) {
+	st := c.named.Underlying().(*types.Struct)
+	docs := make(map[string]bool)
+	for i := 0; i < st.NumFields(); i++ {
+		docs[c.optionDoc(st.Field(i).Name())] = true
+	}
+
+	for _, file := range f.dfiles {
+		decls := file.Decls[:0]
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); ok && fn.Recv == nil && len(fn.Decs.Start) > 0 {
+				doc := fn.Decs.Start[0]
+				if fn.Name.Name != "New"+c.named.Obj().Name() && c.generated(fn.Name.Name, doc) && !docs[doc] {
+					continue
+				}
+			}
+			decls = append(decls, decl)
+		}
+		file.Decls = decls
+	}
+}
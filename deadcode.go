@@ -0,0 +1,359 @@
+package tools
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// Unused is a declaration that is not referenced by any of the live
+// code of the package
+type Unused struct {
+	Position token.Position
+	Kind     string
+	Name     string
+}
+
+// deadCode finds the package level declarations that can not be reached
+// from the exported API, init and main functions, linknamed and cgo
+// exported functions or variables initialized with side effects
+type deadCode struct {
+	check     *checked
+	owners    map[types.Object]ast.Node
+	uses      map[ast.Node][]types.Object
+	methods   map[*types.TypeName][]*ast.FuncDecl
+	live      map[ast.Node]bool
+	queue     []ast.Node
+	iface     map[string]bool
+	linknamed map[string]bool
+}
+
+func newDeadCode(check *checked) *deadCode {
+	return &deadCode{
+		check:     check,
+		owners:    make(map[types.Object]ast.Node),
+		uses:      make(map[ast.Node][]types.Object),
+		methods:   make(map[*types.TypeName][]*ast.FuncDecl),
+		live:      make(map[ast.Node]bool),
+		iface:     make(map[string]bool),
+		linknamed: make(map[string]bool),
+	}
+}
+
+// directives records the names of declarations that are referenced by
+// //go:linkname or cgo //export directives
+func (dc *deadCode) directives(file *ast.File) {
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			fields := strings.Fields(comment.Text)
+			if len(fields) > 1 && (fields[0] == "//go:linkname" || fields[0] == "//export") {
+				dc.linknamed[fields[1]] = true
+			}
+		}
+	}
+}
+
+// interfaces records the names of the methods of every interface type
+// in the file.  Unexported methods with those names may be called
+// through the interface and are treated as used
+func (dc *deadCode) interfaces(file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		if it, ok := n.(*ast.InterfaceType); ok {
+			if iface, ok := dc.check.info.TypeOf(it).(*types.Interface); ok {
+				for i := 0; i < iface.NumMethods(); i++ {
+					dc.iface[iface.Method(i).Name()] = true
+				}
+			}
+		}
+		return true
+	})
+}
+
+// references records the package level objects referenced by unit
+func (dc *deadCode) references(unit ast.Node) {
+	ast.Inspect(unit, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if obj := dc.check.info.Uses[id]; obj != nil && obj.Pkg() == dc.check.pkg {
+				dc.uses[unit] = append(dc.uses[unit], obj)
+			}
+		}
+		return true
+	})
+}
+
+// hasSideEffects determines if evaluating the values calls a function
+func (dc *deadCode) hasSideEffects(values []ast.Expr) (found bool) {
+	for _, value := range values {
+		ast.Inspect(value, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if tv, ok := dc.check.info.Types[call.Fun]; !ok || !tv.IsType() {
+					found = true
+				}
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// collect records the declarations of the file and marks the ones that
+// are used outside of the package's code as live
+func (dc *deadCode) collect(file *ast.File) {
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			obj := dc.check.info.Defs[d.Name]
+			if obj == nil {
+				continue
+			}
+
+			dc.owners[obj] = d
+			dc.references(d)
+			if d.Recv != nil {
+				if tn := receiverTypeName(obj); tn != nil {
+					dc.methods[tn] = append(dc.methods[tn], d)
+				}
+			} else if obj.Exported() || d.Name.Name == "init" || (d.Name.Name == "main" && dc.check.pkg.Name() == "main") || d.Body == nil || dc.linknamed[d.Name.Name] {
+				dc.mark(d)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if obj := dc.check.info.Defs[s.Name]; obj != nil {
+						dc.owners[obj] = s
+						dc.references(s)
+						if obj.Exported() {
+							dc.mark(s)
+						}
+					}
+				case *ast.ValueSpec:
+					dc.references(s)
+					if dc.hasSideEffects(s.Values) {
+						dc.mark(s)
+					}
+
+					for _, name := range s.Names {
+						if obj := dc.check.info.Defs[name]; obj != nil {
+							dc.owners[obj] = s
+						}
+
+						if name.Name == "_" || ast.IsExported(name.Name) || dc.linknamed[name.Name] {
+							dc.mark(s)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// receiverTypeName returns the type name of the method's receiver
+func receiverTypeName(obj types.Object) *types.TypeName {
+	t := obj.Type().(*types.Signature).Recv().Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj()
+	}
+	return nil
+}
+
+// mark adds the unit to the set of live declarations
+func (dc *deadCode) mark(unit ast.Node) {
+	if unit != nil && !dc.live[unit] {
+		dc.live[unit] = true
+		dc.queue = append(dc.queue, unit)
+	}
+}
+
+// propagate marks everything reachable from the live declarations.
+// The methods of a live type are live if they are exported, linknamed
+// or could be called through an interface
+func (dc *deadCode) propagate() {
+	for len(dc.queue) > 0 {
+		unit := dc.queue[0]
+		dc.queue = dc.queue[1:]
+		for _, obj := range dc.uses[unit] {
+			dc.mark(dc.owners[obj])
+		}
+
+		if ts, ok := unit.(*ast.TypeSpec); ok {
+			for _, fd := range dc.methods[dc.check.info.Defs[ts.Name].(*types.TypeName)] {
+				if name := fd.Name.Name; ast.IsExported(name) || dc.iface[name] || dc.linknamed[name] {
+					dc.mark(fd)
+				}
+			}
+		}
+	}
+}
+
+// analyze returns the unused declarations of the package
+func (dc *deadCode) analyze() (unused []Unused) {
+	files := sortedFilenames(dc.check.files)
+	for _, filename := range files {
+		dc.directives(dc.check.files[filename])
+		dc.interfaces(dc.check.files[filename])
+	}
+
+	for _, filename := range files {
+		dc.collect(dc.check.files[filename])
+	}
+	dc.propagate()
+
+	for obj, unit := range dc.owners {
+		if dc.live[unit] || obj.Name() == "_" {
+			continue
+		}
+
+		u := Unused{Position: dc.check.fset.Position(obj.Pos()), Name: obj.Name()}
+		switch obj := obj.(type) {
+		case *types.Func:
+			u.Kind = "func"
+			if obj.Type().(*types.Signature).Recv() != nil {
+				u.Kind = "method"
+				if tn := receiverTypeName(obj); tn != nil {
+					u.Name = tn.Name() + "." + u.Name
+				}
+			}
+		case *types.TypeName:
+			u.Kind = "type"
+		case *types.Const:
+			u.Kind = "const"
+		case *types.Var:
+			u.Kind = "var"
+		}
+		unused = append(unused, u)
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		pi, pj := unused[i].Position, unused[j].Position
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	return unused
+}
+
+// removable determines if the dead specs of the declaration can be
+// deleted.  Specs of a const block that uses iota or implicit repetition
+// are kept since removing them would change the values of the constants
+// that follow
+func removable(decl *ast.GenDecl) bool {
+	if decl.Tok != token.CONST {
+		return true
+	}
+
+	for _, spec := range decl.Specs {
+		vs := spec.(*ast.ValueSpec)
+		if len(vs.Values) == 0 {
+			return false
+		}
+
+		for _, value := range vs.Values {
+			found := false
+			ast.Inspect(value, func(n ast.Node) bool {
+				id, ok := n.(*ast.Ident)
+				found = found || (ok && id.Name == "iota")
+				return !found
+			})
+
+			if found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// remove deletes the dead declarations from the session's files along
+// with the imports that are no longer used
+func (dc *deadCode) remove(f *Tools) {
+	for filename, file := range dc.check.files {
+		dfile := f.dfiles[filename]
+		dead := make(map[dst.Node]bool)
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if _, found := dc.owners[dc.check.info.Defs[d.Name]]; found && !dc.live[d] {
+					dead[dc.check.nodes.Dst.Nodes[d]] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if _, ok := spec.(*ast.ImportSpec); !ok && !dc.live[spec] && removable(d) {
+						dead[dc.check.nodes.Dst.Nodes[spec]] = true
+					}
+				}
+			}
+		}
+
+		if len(dead) == 0 {
+			continue
+		}
+
+		decls := dfile.Decls[:0]
+		for _, decl := range dfile.Decls {
+			if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok != token.IMPORT {
+				specs := gd.Specs[:0]
+				for _, spec := range gd.Specs {
+					if !dead[spec] {
+						specs = append(specs, spec)
+					}
+				}
+				gd.Specs = specs
+				if len(specs) == 0 {
+					continue
+				}
+			} else if dead[decl] {
+				continue
+			}
+			decls = append(decls, decl)
+		}
+		dfile.Decls = decls
+
+		for _, spec := range file.Imports {
+			if pkgName := importedPackage(dc.check, spec); pkgName != nil {
+				pruneImport(dfile, pkgName.Imported().Path(), pkgName.Imported().Name())
+			}
+		}
+	}
+}
+
+// importedPackage returns the package name object declared by the
+// import spec
+func importedPackage(check *checked, spec *ast.ImportSpec) *types.PkgName {
+	var obj types.Object
+	if spec.Name != nil {
+		obj = check.info.Defs[spec.Name]
+	} else {
+		obj = check.info.Implicits[spec]
+	}
+	pkgName, _ := obj.(*types.PkgName)
+	return pkgName
+}
+
+// DeadCode finds the unexported declarations of the package that can not
+// be reached from its exported API, init, main, tests or directives.  If
+// remove is true they are deleted along with the imports they leave unused
+func (f *Tools) DeadCode(remove bool) (unused []Unused, err error) {
+	defer f.operation()()
+	check := f.check()
+	dc := newDeadCode(check)
+	unused = dc.analyze()
+	if remove && len(unused) > 0 {
+		starts := f.snapshot()
+		f.install(check)
+		dc.remove(f)
+		if rerr := f.recordAll(starts); err == nil {
+			err = rerr
+		}
+	}
+	return unused, err
+}
@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestDeadCode(t *testing.T) {
+	input, err := ioutil.ReadFile("testdata/tools_test/DeadCode_01.input")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tools := New()
+	if err := tools.Add("foo.go", input); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	unused, err := tools.DeadCode(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := []string{}
+	for _, u := range unused {
+		got = append(got, u.Kind+" "+u.Name)
+	}
+
+	want := []string{
+		"method square.perimeter",
+		"type unusedType",
+		"method unusedType.String",
+		"const first",
+		"const second",
+		"const limit",
+		"func helper",
+		"func deeper",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Wanted unused %v got %v", want, got)
+	}
+}
+
+func TestDeadCodeReport(t *testing.T) {
+	tools := New()
+	if err := tools.Add("foo.go", []byte("package foo\n\nfunc unused() {}\n")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	file := tools.dfiles["foo.go"]
+	unused, err := tools.DeadCode(false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(unused) != 1 {
+		t.Errorf("Wanted 1 unused declaration got %d", len(unused))
+	}
+
+	if tools.dfiles["foo.go"] != file {
+		t.Errorf("Wanted reporting to leave the file's tree alone")
+	}
+}
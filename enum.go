@@ -0,0 +1,309 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"sort"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// EnumMethods selects the methods that GenerateEnums adds to an enum
+// type in addition to String
+type EnumMethods int
+
+const (
+	// EnumText generates MarshalText and UnmarshalText methods
+	EnumText EnumMethods = 1 << iota
+
+	// EnumValues generates a function that lists the enum's values
+	EnumValues
+)
+
+// enum is a named integer type along with the package level constants
+// declared with that type, in the order they are declared
+type enum struct {
+	named  *types.Named
+	consts []*types.Const
+}
+
+// enums finds the named integer types of the checked package that have
+// constants declared for them
+func (c *checked) enums() (enums []*enum) {
+	found := make(map[*types.Named]*enum)
+	scope := c.pkg.Scope()
+	for _, name := range scope.Names() {
+		obj, ok := scope.Lookup(name).(*types.Const)
+		if !ok {
+			continue
+		}
+
+		named, ok := obj.Type().(*types.Named)
+		if !ok || named.Obj().Pkg() != c.pkg {
+			continue
+		}
+
+		if basic, ok := named.Underlying().(*types.Basic); !ok || basic.Info()&types.IsInteger == 0 {
+			continue
+		}
+
+		if found[named] == nil {
+			found[named] = &enum{named: named}
+			enums = append(enums, found[named])
+		}
+		found[named].consts = append(found[named].consts, obj)
+	}
+
+	for _, e := range enums {
+		sort.Slice(e.consts, func(i, j int) bool { return e.consts[i].Pos() < e.consts[j].Pos() })
+	}
+	sort.Slice(enums, func(i, j int) bool { return enums[i].named.Obj().Name() < enums[j].named.Obj().Name() })
+	return enums
+}
+
+// unique returns the first constant declared for each value
+func (e *enum) unique() (consts []*types.Const) {
+	seen := make(map[string]bool)
+	for _, c := range e.consts {
+		if value := c.Val().ExactString(); !seen[value] {
+			seen[value] = true
+			consts = append(consts, c)
+		}
+	}
+	return consts
+}
+
+// docs returns the doc comment of each function that GenerateEnums
+// can generate for the enum, which identifies the functions that were
+// generated by a previous run
+func (e *enum) docs() map[string]string {
+	typ := e.named.Obj().Name()
+	return map[string]string{
+		"String":        fmt.Sprintf("// String returns the name of the %s constant", typ),
+		"MarshalText":   fmt.Sprintf("// MarshalText encodes the %s as the name of its constant", typ),
+		"UnmarshalText": fmt.Sprintf("// UnmarshalText decodes the name of a %s constant", typ),
+		typ + "Values":  fmt.Sprintf("// %sValues returns every %s value in the order they are declared", typ, typ),
+	}
+}
+
+// declared returns the existing declarations, and their files, of the
+// functions that GenerateEnums can generate for the enum
+func (e *enum) declared(f *Tools) map[*dst.FuncDecl]string {
+	typ := e.named.Obj().Name()
+	docs := e.docs()
+	declared := make(map[*dst.FuncDecl]string)
+	for filename, file := range f.dfiles {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*dst.FuncDecl)
+			if !ok || docs[fn.Name.Name] == "" {
+				continue
+			}
+
+			if (fn.Recv == nil && fn.Name.Name == typ+"Values") || (fn.Recv != nil && typStr(fn.Recv.List[0].Type) == typ) {
+				declared[fn] = filename
+			}
+		}
+	}
+	return declared
+}
+
+// selected returns the names of the functions generated for the enum
+// with the methods
+func (e *enum) selected(methods EnumMethods) map[string]bool {
+	selected := map[string]bool{"String": true}
+	if methods&EnumText != 0 {
+		selected["MarshalText"], selected["UnmarshalText"] = true, true
+	}
+
+	if methods&EnumValues != 0 {
+		selected[e.named.Obj().Name()+"Values"] = true
+	}
+	return selected
+}
+
+// generated determines if the function was generated, going by its doc
+// comment
+func (e *enum) generated(fn *dst.FuncDecl) bool {
+	return len(fn.Decs.Start) > 0 && fn.Decs.Start[0] == e.docs()[fn.Name.Name]
+}
+
+// source generates the source of the enum's methods.  The names of
+// the packages needed by the generated code are given by fmtName and
+// strconvName
+func (e *enum) source(methods EnumMethods, fmtName, strconvName string) []byte {
+	typ := e.named.Obj().Name()
+	recv := receiverName(e.named)
+	buf := &bytes.Buffer{}
+
+	format := fmt.Sprintf("%s.FormatInt(int64(%s), 10)", strconvName, recv)
+	if basic := e.named.Underlying().(*types.Basic); basic.Info()&types.IsUnsigned != 0 {
+		format = fmt.Sprintf("%s.FormatUint(uint64(%s), 10)", strconvName, recv)
+	}
+
+	docs := e.docs()
+	fmt.Fprintf(buf, "%s\n", docs["String"])
+	fmt.Fprintf(buf, "func (%s %s) String() string {\n\tswitch %s {\n", recv, typ, recv)
+	for _, c := range e.unique() {
+		fmt.Fprintf(buf, "\tcase %s:\n\t\treturn %q\n", c.Name(), c.Name())
+	}
+	fmt.Fprintf(buf, "\t}\n\treturn \"%s(\" + %s + \")\"\n}\n\n", typ, format)
+
+	if methods&EnumText != 0 {
+		fmt.Fprintf(buf, "%s\n", docs["MarshalText"])
+		fmt.Fprintf(buf, "func (%s %s) MarshalText() ([]byte, error) {\n\treturn []byte(%s.String()), nil\n}\n\n", recv, typ, recv)
+
+		fmt.Fprintf(buf, "%s\n", docs["UnmarshalText"])
+		fmt.Fprintf(buf, "func (%s *%s) UnmarshalText(text []byte) error {\n\tswitch string(text) {\n", recv, typ)
+		for _, c := range e.consts {
+			fmt.Fprintf(buf, "\tcase %q:\n\t\t*%s = %s\n", c.Name(), recv, c.Name())
+		}
+		fmt.Fprintf(buf, "\tdefault:\n\t\treturn %s.Errorf(\"invalid %s %%q\", text)\n\t}\n\treturn nil\n}\n\n", fmtName, typ)
+	}
+
+	if methods&EnumValues != 0 {
+		fmt.Fprintf(buf, "%s\n", docs[typ+"Values"])
+		fmt.Fprintf(buf, "func %sValues() []%s {\n\treturn []%s{\n", typ, typ, typ)
+		for _, c := range e.unique() {
+			fmt.Fprintf(buf, "\t\t%s,\n", c.Name())
+		}
+		fmt.Fprintf(buf, "\t}\n}\n")
+	}
+	return buf.Bytes()
+}
+
+// insertValues adds the new values function to the file after the
+// type's declarations and methods
+func (e *enum) insertValues(file *dst.File, fn *dst.FuncDecl) {
+	o := &organizer{file: file, types: map[string]sortableSource{e.named.Obj().Name(): nil}}
+	index := -1
+	for i, decl := range file.Decls {
+		if o.group(decl) == e.named.Obj().Name() {
+			index = i + 1
+		}
+	}
+
+	if index < 0 {
+		o.insert(fn)
+		return
+	}
+	fn.Decs.Before = dst.EmptyLine
+	fn.Decs.After = dst.EmptyLine
+	file.Decls = append(file.Decls[:index], append([]dst.Decl{fn}, file.Decls[index:]...)...)
+}
+
+// GenerateEnums adds a String method, and the selected methods, to the
+// package's named integer types with constants, or only to the named
+// ones.  Methods from a previous run are replaced, in whichever file
+// they are in, or removed, but ErrDeclExists is returned rather than
+// replacing hand written ones
+func (f *Tools) GenerateEnums(methods EnumMethods, names ...string) error {
+	defer f.operation()()
+	check := f.edit()
+	enums := check.enums()
+	if len(names) > 0 {
+		selected := []*enum{}
+		for _, name := range names {
+			var found *enum
+			for _, e := range enums {
+				if e.named.Obj().Name() == name {
+					found = e
+				}
+			}
+
+			if found == nil {
+				return fmt.Errorf("%w: enum %s", ErrDeclNotFound, name)
+			}
+			selected = append(selected, found)
+		}
+		enums = selected
+	}
+
+	for _, e := range enums {
+		selected := e.selected(methods)
+		for fn := range e.declared(f) {
+			if selected[fn.Name.Name] && !e.generated(fn) {
+				return fmt.Errorf("%w: %s was not generated", ErrDeclExists, fn.Name.Name)
+			}
+		}
+	}
+
+	starts := f.snapshot()
+	for _, e := range enums {
+		// remove the generated methods that are no longer selected
+		selected := e.selected(methods)
+		for fn, filename := range e.declared(f) {
+			if selected[fn.Name.Name] || !e.generated(fn) {
+				continue
+			}
+
+			file := f.dfiles[filename]
+			for i, decl := range file.Decls {
+				if decl == fn {
+					file.Decls = append(file.Decls[:i], file.Decls[i+1:]...)
+					break
+				}
+			}
+		}
+
+		// previously generated functions are replaced in the files they
+		// were moved to while new ones go in the type's file
+		typeFile := check.fset.File(e.named.Obj().Pos()).Name()
+		files := make(map[string]string)
+		for name := range selected {
+			files[name] = typeFile
+		}
+
+		existing := make(map[string]bool)
+		for fn, filename := range e.declared(f) {
+			if selected[fn.Name.Name] {
+				files[fn.Name.Name] = filename
+				existing[fn.Name.Name] = true
+			}
+		}
+
+		targets := make(map[string]bool)
+		for _, filename := range files {
+			targets[filename] = true
+		}
+
+		filenames := []string{}
+		for filename := range targets {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+
+		for _, filename := range filenames {
+			file := f.dfiles[filename]
+			fmtName, strconvName := "fmt", "strconv"
+			if files["UnmarshalText"] == filename {
+				fmtName = addImport(file, "fmt", "fmt")
+			}
+			if files["String"] == filename {
+				strconvName = addImport(file, "strconv", "strconv")
+			}
+
+			src := fmt.Sprintf("package %s\n\n%s", file.Name.Name, e.source(methods, fmtName, strconvName))
+			generated, err := decorator.Parse(src)
+			if err != nil {
+				return err
+			}
+
+			for _, decl := range generated.Decls {
+				fn := decl.(*dst.FuncDecl)
+				if files[fn.Name.Name] != filename {
+					continue
+				}
+
+				if fn.Recv == nil && !existing[fn.Name.Name] {
+					e.insertValues(file, fn)
+				} else {
+					f.upsert(filename, fn)
+				}
+			}
+		}
+	}
+
+	return f.recordAll(starts)
+}
@@ -0,0 +1,60 @@
+package tools
+
+import "testing"
+
+func TestGenerateEnumsMoved(t *testing.T) {
+	session := newSession(t, "example.com/foo", map[string]string{
+		"color.go": `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+`,
+		"strings.go": `package foo
+
+import "strconv"
+
+// String returns the name of the Color constant
+func (c Color) String() string {
+	switch c {
+	case Red:
+		return "Red"
+	}
+	return "Color(" + strconv.FormatInt(int64(c), 10) + ")"
+}
+`,
+	})
+
+	if err := session.GenerateEnums(0, "Color"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	wantSource(t, session, "color.go", `package foo
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+`)
+
+	wantSource(t, session, "strings.go", `package foo
+
+import "strconv"
+
+// String returns the name of the Color constant
+func (c Color) String() string {
+	switch c {
+	case Red:
+		return "Red"
+	case Green:
+		return "Green"
+	}
+	return "Color(" + strconv.FormatInt(int64(c), 10) + ")"
+}
+`)
+}
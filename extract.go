@@ -0,0 +1,631 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"sort"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+)
+
+type extractor struct {
+	check *checked
+	file  *ast.File
+	name  string
+
+	start token.Pos
+	end   token.Pos
+
+	// the function (declaration or literal) enclosing the statements
+	decl  *ast.FuncDecl
+	ftype *ast.FuncType
+	body  *ast.BlockStmt
+	sig   *types.Signature
+
+	// the statement list that the selected statements belong to
+	list  []ast.Stmt
+	stmts []ast.Stmt
+	owner ast.Node
+
+	params  []*types.Var
+	results []*types.Var
+	defined map[*types.Var]bool
+	recv    bool
+	returns []*ast.ReturnStmt
+}
+
+// position converts a line and column in the file to a token.Pos.  A
+// column of zero refers to the start of the line, or the end of the line
+// when end is true
+func (e *extractor) position(line, column int, end bool) (token.Pos, error) {
+	tf := e.check.fset.File(e.file.Pos())
+	if line < 1 || line > tf.LineCount() {
+		return token.NoPos, fmt.Errorf("%w: line %d is outside of the file", ErrInvalidRange, line)
+	}
+
+	pos := tf.LineStart(line)
+	if column > 0 {
+		return pos + token.Pos(column-1), nil
+	}
+
+	if end {
+		if line == tf.LineCount() {
+			return token.Pos(tf.Base() + tf.Size()), nil
+		}
+		return tf.LineStart(line+1) - 1, nil
+	}
+	return pos, nil
+}
+
+// find locates the statements that are completely covered by the
+// range, which must all belong to the same statement list
+func (e *extractor) find() error {
+	for _, decl := range e.file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil && fn.Body.Pos() <= e.start && e.end <= fn.Body.End() {
+			e.decl = fn
+			e.ftype = fn.Type
+			e.body = fn.Body
+			e.sig, _ = e.check.info.Defs[fn.Name].Type().(*types.Signature)
+		}
+	}
+
+	if e.decl == nil {
+		return fmt.Errorf("%w: no function encloses the range", ErrInvalidRange)
+	}
+
+	ast.Inspect(e.decl.Body, func(n ast.Node) bool {
+		if e.stmts != nil || n == nil {
+			return false
+		}
+
+		var list []ast.Stmt
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if n.Body.Pos() <= e.start && e.end <= n.Body.End() {
+				e.ftype = n.Type
+				e.body = n.Body
+				e.sig, _ = e.check.info.TypeOf(n).(*types.Signature)
+			}
+		case *ast.BlockStmt:
+			list = n.List
+		case *ast.CaseClause:
+			list = n.Body
+		case *ast.CommClause:
+			list = n.Body
+		}
+
+		var selected []ast.Stmt
+		for _, stmt := range list {
+			if e.start <= stmt.Pos() && stmt.End() <= e.end {
+				selected = append(selected, stmt)
+			} else if stmt.Pos() < e.end && e.start < stmt.End() {
+				// partially selected statement, look deeper
+				return true
+			}
+		}
+
+		if len(selected) > 0 {
+			e.list = list
+			e.stmts = selected
+			e.owner = n
+			return false
+		}
+		return true
+	})
+
+	if e.stmts == nil {
+		return fmt.Errorf("%w: no complete statements are selected", ErrInvalidRange)
+	}
+	e.start = e.stmts[0].Pos()
+	e.end = e.stmts[len(e.stmts)-1].End()
+	return e.checkBranches()
+}
+
+// checkBranches makes sure that the selection does not contain any
+// branch statements that jump outside of it
+func (e *extractor) checkBranches() (err error) {
+	labels := make(map[string]bool)
+	for _, stmt := range e.stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			if ls, ok := n.(*ast.LabeledStmt); ok {
+				labels[ls.Label.Name] = true
+			}
+			return true
+		})
+	}
+
+	var walk func(n ast.Node, loop, brk bool)
+	walk = func(n ast.Node, loop, brk bool) {
+		ast.Inspect(n, func(c ast.Node) bool {
+			if c == n || err != nil {
+				return err == nil
+			}
+
+			switch c := c.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ForStmt, *ast.RangeStmt:
+				walk(c, true, true)
+				return false
+			case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				walk(c, loop, true)
+				return false
+			case *ast.BranchStmt:
+				switch {
+				case c.Label != nil && !labels[c.Label.Name]:
+					err = fmt.Errorf("%w: jump to label %s outside of the selection", ErrUnsupported, c.Label.Name)
+				case c.Label == nil && c.Tok == token.CONTINUE && !loop:
+					err = fmt.Errorf("%w: continue outside of a selected loop", ErrUnsupported)
+				case c.Label == nil && c.Tok == token.BREAK && !brk:
+					err = fmt.Errorf("%w: break outside of a selected loop or switch", ErrUnsupported)
+				case c.Tok == token.FALLTHROUGH && !brk:
+					err = fmt.Errorf("%w: fallthrough out of the selection", ErrUnsupported)
+				}
+			}
+			return true
+		})
+	}
+
+	for _, stmt := range e.stmts {
+		walk(&ast.BlockStmt{List: []ast.Stmt{stmt}}, false, false)
+	}
+	return err
+}
+
+// inRange determines if the position is within the selected statements
+func (e *extractor) inRange(pos token.Pos) bool {
+	return e.start <= pos && pos < e.end
+}
+
+// local determines if the object is a local variable of the function
+// that encloses the selection
+func (e *extractor) local(obj types.Object) (*types.Var, bool) {
+	v, ok := obj.(*types.Var)
+	if !ok || v.IsField() || v.Pkg() != e.check.pkg {
+		return nil, false
+	}
+	return v, e.decl.Pos() <= v.Pos() && v.Pos() < e.decl.End()
+}
+
+// analyze computes the parameters and results of the new function
+func (e *extractor) analyze() error {
+	seen := make(map[*types.Var]bool)
+	assigned := make(map[*types.Var]bool)
+	e.defined = make(map[*types.Var]bool)
+
+	var recv *types.Var
+	if e.decl.Recv != nil && len(e.decl.Recv.List[0].Names) > 0 {
+		recv, _ = e.check.info.Defs[e.decl.Recv.List[0].Names[0]].(*types.Var)
+	}
+
+	assign := func(expr ast.Expr) {
+		if id, ok := unparen(expr).(*ast.Ident); ok {
+			if v, ok := e.local(e.check.info.Uses[id]); ok {
+				assigned[v] = true
+			}
+		}
+	}
+
+	for _, stmt := range e.stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.Ident:
+				if v, ok := e.local(e.check.info.Defs[n]); ok {
+					e.defined[v] = true
+				} else if v, ok := e.local(e.check.info.Uses[n]); ok && !e.inRange(v.Pos()) && !seen[v] {
+					seen[v] = true
+					if v == recv {
+						e.recv = true
+					} else {
+						e.params = append(e.params, v)
+					}
+				}
+			case *ast.AssignStmt:
+				if n.Tok != token.DEFINE {
+					for _, lhs := range n.Lhs {
+						assign(lhs)
+					}
+				}
+			case *ast.IncDecStmt:
+				assign(n.X)
+			case *ast.RangeStmt:
+				if n.Tok == token.ASSIGN {
+					assign(n.Key)
+					if n.Value != nil {
+						assign(n.Value)
+					}
+				}
+			case *ast.UnaryExpr:
+				if n.Op == token.AND {
+					assign(n.X)
+				}
+			}
+			return true
+		})
+	}
+
+	var err error
+	for _, stmt := range e.stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				// returns, defers and recovers in a function literal
+				// belong to the literal
+				return false
+			case *ast.ReturnStmt:
+				e.returns = append(e.returns, n)
+			case *ast.DeferStmt:
+				err = fmt.Errorf("%w: deferred calls would run when the new function returns", ErrUnsupported)
+			case *ast.CallExpr:
+				if id, ok := unparen(n.Fun).(*ast.Ident); ok {
+					if b, ok := e.check.info.Uses[id].(*types.Builtin); ok && b.Name() == "recover" {
+						err = fmt.Errorf("%w: recover would not stop a panic in the enclosing function", ErrUnsupported)
+					}
+				}
+			}
+			return err == nil
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// any variable defined or assigned in the selection that is used
+	// afterwards must be returned from the new function
+	results := make(map[*types.Var]bool)
+	ast.Inspect(e.body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Pos() >= e.end {
+			if v, ok := e.local(e.check.info.Uses[id]); ok && (e.defined[v] || assigned[v]) && !results[v] {
+				results[v] = true
+				e.results = append(e.results, v)
+			}
+		}
+		return true
+	})
+
+	// as must any variable assigned in the selection that is read again
+	// by an enclosing loop or by a function literal declared before it
+	changed := func(v *types.Var) bool { return (e.defined[v] || assigned[v]) && !results[v] }
+	ast.Inspect(e.body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			if n.Pos() <= e.start && e.end <= n.End() {
+				e.carried(n, changed, results)
+			}
+		case *ast.FuncLit:
+			if n.End() <= e.start {
+				e.carried(n, changed, results)
+			}
+		}
+		return true
+	})
+	sort.Slice(e.results, func(i, j int) bool { return e.results[i].Pos() < e.results[j].Pos() })
+
+	if len(e.results) > 0 && len(e.returns) > 0 && e.tail() {
+		return fmt.Errorf("%w: %s is still used after the selection returns", ErrUnsupported, e.results[0].Name())
+	}
+
+	for _, ret := range e.returns {
+		if len(ret.Results) == 0 && e.ftype.Results != nil && len(e.ftype.Results.List) > 0 {
+			return fmt.Errorf("%w: bare return with named results", ErrUnsupported)
+		}
+	}
+	return nil
+}
+
+// carried adds the changed variables that are used within n, outside of
+// the selection, to the results
+func (e *extractor) carried(n ast.Node, changed func(*types.Var) bool, results map[*types.Var]bool) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && !e.inRange(id.Pos()) {
+			if v, ok := e.local(e.check.info.Uses[id]); ok && changed(v) {
+				results[v] = true
+				e.results = append(e.results, v)
+			}
+		}
+		return true
+	})
+}
+
+// tail determines if the selection ends the enclosing function in such
+// a way that the call to the new function can simply be returned
+func (e *extractor) tail() bool {
+	if e.owner != e.body || e.stmts[len(e.stmts)-1] != e.list[len(e.list)-1] {
+		return false
+	}
+
+	if e.ftype.Results == nil || len(e.ftype.Results.List) == 0 {
+		return true
+	}
+	_, ok := e.stmts[len(e.stmts)-1].(*ast.ReturnStmt)
+	return ok
+}
+
+// unique returns a name, based on name, that is not used by any
+// identifier in the enclosing function
+func (e *extractor) unique(name string) string {
+	names := make(map[string]bool)
+	ast.Inspect(e.decl, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			names[id.Name] = true
+		}
+		return true
+	})
+
+	candidate := name
+	for i := 1; names[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	return candidate
+}
+
+// extract builds the new function, replaces the selected statements
+// with a call to it and returns the new declaration
+func (e *extractor) extract() *dst.FuncDecl {
+	nodes := e.check.nodes.Dst.Nodes
+	stmts := []dst.Stmt{}
+	for _, stmt := range e.stmts {
+		stmts = append(stmts, nodes[stmt].(dst.Stmt))
+	}
+
+	fn := &dst.FuncDecl{
+		Name: dst.NewIdent(e.name),
+		Type: &dst.FuncType{Func: true, Params: &dst.FieldList{Opening: true, Closing: true}},
+		Body: &dst.BlockStmt{},
+	}
+
+	call := &dst.CallExpr{Fun: dst.NewIdent(e.name)}
+	if e.recv {
+		recv := nodes[e.decl].(*dst.FuncDecl).Recv
+		fn.Recv = dst.Clone(recv).(*dst.FieldList)
+		call.Fun = &dst.SelectorExpr{X: dst.NewIdent(recv.List[0].Names[0].Name), Sel: dst.NewIdent(e.name)}
+	}
+
+	for _, param := range e.params {
+		fn.Type.Params.List = append(fn.Type.Params.List, &dst.Field{
+			Names: []*dst.Ident{dst.NewIdent(param.Name())},
+			Type:  e.check.typeExpr(param.Type()),
+		})
+		call.Args = append(call.Args, dst.NewIdent(param.Name()))
+	}
+
+	results := &dst.FieldList{}
+	outs := []dst.Expr{}
+	lhs := []*types.Var{}
+	for _, result := range e.results {
+		results.List = append(results.List, &dst.Field{Type: e.check.typeExpr(result.Type())})
+		outs = append(outs, dst.NewIdent(result.Name()))
+		lhs = append(lhs, result)
+	}
+
+	var replacement []dst.Stmt
+	switch {
+	case len(e.returns) > 0 && e.tail():
+		// the names of the results would collide with the parameters
+		// that the selection's uses of them become
+		if n := e.sig.Results().Len(); n > 0 {
+			fn.Type.Results = &dst.FieldList{Opening: n > 1, Closing: n > 1}
+			for i := 0; i < n; i++ {
+				fn.Type.Results.List = append(fn.Type.Results.List, &dst.Field{Type: e.check.typeExpr(e.sig.Results().At(i).Type())})
+			}
+		}
+		fn.Body.List = stmts
+		if fn.Type.Results != nil {
+			replacement = []dst.Stmt{&dst.ReturnStmt{Results: []dst.Expr{call}}}
+		} else {
+			replacement = []dst.Stmt{&dst.ExprStmt{X: call}}
+		}
+	case len(e.returns) > 0:
+		// the enclosing function must return when the new function
+		// returns early, so the new function returns the enclosing
+		// function's results and a flag indicating an early return
+		rets := []*types.Var{}
+		for i := 0; i < e.sig.Results().Len(); i++ {
+			v := types.NewVar(token.NoPos, e.check.pkg, e.unique(fmt.Sprintf("ret%d", i)), e.sig.Results().At(i).Type())
+			rets = append(rets, v)
+			results.List = append(results.List, &dst.Field{Type: e.check.typeExpr(v.Type())})
+		}
+		flag := types.NewVar(token.NoPos, e.check.pkg, e.unique("shouldReturn"), types.Typ[types.Bool])
+		results.List = append(results.List, &dst.Field{Type: dst.NewIdent("bool")})
+
+		zeros := []dst.Expr{}
+		for _, result := range e.results {
+			zeros = append(zeros, e.check.zeroValue(result.Type()))
+		}
+
+		for _, stmt := range stmts {
+			dstutil.Apply(stmt, func(cursor *dstutil.Cursor) bool {
+				switch n := cursor.Node().(type) {
+				case *dst.FuncLit:
+					return false
+				case *dst.ReturnStmt:
+					results := append(append([]dst.Expr{}, zeros...), n.Results...)
+					n.Results = append(cloneExprs(results), dst.NewIdent("true"))
+				}
+				return true
+			}, nil)
+		}
+
+		final := cloneExprs(outs)
+		for _, ret := range rets {
+			final = append(final, e.check.zeroValue(ret.Type()))
+		}
+		fn.Type.Results = results
+		fn.Body.List = append(stmts, &dst.ReturnStmt{Results: append(final, dst.NewIdent("false"))})
+
+		retIdents := []dst.Expr{}
+		for _, ret := range rets {
+			retIdents = append(retIdents, dst.NewIdent(ret.Name()))
+		}
+		lhs = append(append(lhs, rets...), flag)
+		replacement = append(e.assign(lhs, call, rets, flag), &dst.IfStmt{
+			Cond: dst.NewIdent(flag.Name()),
+			Body: &dst.BlockStmt{List: []dst.Stmt{&dst.ReturnStmt{Results: retIdents}}},
+		})
+	default:
+		fn.Body.List = stmts
+		if len(e.results) > 0 {
+			fn.Type.Results = results
+			fn.Body.List = append(fn.Body.List, &dst.ReturnStmt{Results: cloneExprs(outs)})
+			replacement = e.assign(lhs, call, nil, nil)
+		} else {
+			replacement = []dst.Stmt{&dst.ExprStmt{X: call}}
+		}
+	}
+
+	// carry the comments surrounding the selection over to the call
+	first, last := stmts[0].Decorations(), stmts[len(stmts)-1].Decorations()
+	replacement[0].Decorations().Before = first.Before
+	replacement[0].Decorations().Start = first.Start
+	replacement[len(replacement)-1].Decorations().After = last.After
+	first.Before = dst.NewLine
+	first.Start = nil
+	last.After = dst.NewLine
+
+	e.replace(replacement)
+	return fn
+}
+
+// assign generates the statements that assign the results of the call
+// to the variables in lhs.  If all of the variables are new then a short
+// variable declaration is used, otherwise the new variables are declared
+// first
+func (e *extractor) assign(lhs []*types.Var, call dst.Expr, rets []*types.Var, flag *types.Var) (stmts []dst.Stmt) {
+	isNew := func(v *types.Var) bool {
+		if e.defined[v] || v == flag {
+			return true
+		}
+		for _, ret := range rets {
+			if ret == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	allNew := true
+	for _, v := range lhs {
+		allNew = allNew && isNew(v)
+	}
+
+	as := &dst.AssignStmt{Tok: token.DEFINE, Rhs: []dst.Expr{call}}
+	for _, v := range lhs {
+		as.Lhs = append(as.Lhs, dst.NewIdent(v.Name()))
+		if !allNew && isNew(v) {
+			stmts = append(stmts, &dst.DeclStmt{Decl: &dst.GenDecl{
+				Tok:   token.VAR,
+				Specs: []dst.Spec{&dst.ValueSpec{Names: []*dst.Ident{dst.NewIdent(v.Name())}, Type: e.check.typeExpr(v.Type())}},
+			}})
+		}
+	}
+
+	if !allNew {
+		as.Tok = token.ASSIGN
+	}
+	return append(stmts, as)
+}
+
+// replace swaps the selected statements in their statement list for
+// the replacement statements
+func (e *extractor) replace(replacement []dst.Stmt) {
+	nodes := e.check.nodes.Dst.Nodes
+	var list *[]dst.Stmt
+	switch n := nodes[e.owner].(type) {
+	case *dst.BlockStmt:
+		list = &n.List
+	case *dst.CaseClause:
+		list = &n.Body
+	case *dst.CommClause:
+		list = &n.Body
+	}
+
+	first := nodes[e.stmts[0]].(dst.Stmt)
+	last := nodes[e.stmts[len(e.stmts)-1]].(dst.Stmt)
+	start, end := 0, 0
+	for i, stmt := range *list {
+		if stmt == first {
+			start = i
+		}
+		if stmt == last {
+			end = i + 1
+		}
+	}
+
+	stmts := append([]dst.Stmt{}, (*list)[:start]...)
+	stmts = append(stmts, replacement...)
+	*list = append(stmts, (*list)[end:]...)
+}
+
+// cloneExprs returns a deep copy of the expressions
+func cloneExprs(exprs []dst.Expr) []dst.Expr {
+	clones := make([]dst.Expr, len(exprs))
+	for i, expr := range exprs {
+		clones[i] = dst.Clone(expr).(dst.Expr)
+	}
+	return clones
+}
+
+// unparen returns the expression with any enclosing parentheses removed
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
+
+// ExtractFunc moves the statements between the start and end lines, or
+// positions if a Column is given, into a new function called name and
+// replaces them with a call to it.  The function is created as a method
+// if the statements use the receiver of the enclosing method
+func (f *Tools) ExtractFunc(filename string, start, end token.Position, name string) ([]byte, error) {
+	defer f.operation()()
+	if _, found := f.dfiles[filename]; !found {
+		return nil, fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
+	}
+
+	e := &extractor{
+		check: f.edit(),
+		name:  name,
+	}
+	e.file = e.check.files[filename]
+
+	var err error
+	if e.start, err = e.position(start.Line, start.Column, false); err != nil {
+		return nil, err
+	}
+
+	if e.end, err = e.position(end.Line, end.Column, true); err != nil {
+		return nil, err
+	}
+
+	if err = e.find(); err == nil {
+		err = e.analyze()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if e.recv {
+		recv := e.check.info.TypeOf(e.decl.Recv.List[0].Type)
+		if obj, _, _ := types.LookupFieldOrMethod(recv, true, e.check.pkg, name); obj != nil {
+			return nil, fmt.Errorf("%w: %s is already a field or method of the receiver", ErrDeclExists, name)
+		}
+	} else if e.check.pkg.Scope().Lookup(name) != nil {
+		return nil, fmt.Errorf("%w: %s is already declared", ErrDeclExists, name)
+	}
+
+	return f.format(filename, func() {
+		fn := e.extract()
+		o := &organizer{file: f.dfiles[filename]}
+		o.insert(fn)
+	})
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// overlayKey returns the key of the file in the overlay.  Paths of the
+// operating system's file system are made absolute so that relative
+// and absolute names of a file match
+func (f *Tools) overlayKey(filename string) string {
+	if f.fsys != nil {
+		return path.Clean(filename)
+	}
+
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filepath.Clean(filename)
+}
+
+// readFile returns the content of the file from the overlay or, if it
+// is not in the overlay, the file system
+func (f *Tools) readFile(filename string) ([]byte, error) {
+	if content, found := f.overlay[f.overlayKey(filename)]; found {
+		return content, nil
+	}
+
+	if f.fsys != nil {
+		return fs.ReadFile(f.fsys, filename)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// glob returns the sorted names of the *.go files in dir from both the
+// file system and the overlay
+func (f *Tools) glob(dir string) (files []string, err error) {
+	join, dirOf, base := filepath.Join, filepath.Dir, filepath.Base
+	if f.fsys != nil {
+		join, dirOf, base = path.Join, path.Dir, path.Base
+		files, err = fs.Glob(f.fsys, path.Join(dir, "*.go"))
+	} else {
+		files, err = filepath.Glob(filepath.Join(dir, "*.go"))
+	}
+
+	if err != nil || len(f.overlay) == 0 {
+		return files, err
+	}
+
+	found := make(map[string]bool)
+	for _, file := range files {
+		found[f.overlayKey(file)] = true
+	}
+
+	key := f.overlayKey(dir)
+	for filename := range f.overlay {
+		if dirOf(filename) == key && !found[filename] && path.Ext(filename) == ".go" {
+			// name the file relative to dir, as the file system's files are
+			files = append(files, join(dir, base(filename)))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAddFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pkg/a.go":      {Data: []byte("package pkg\n\nfunc b() {}\n\nfunc a() {}\n")},
+		"pkg/b.go":      {Data: []byte("package pkg\n")},
+		"pkg/README.md": {Data: []byte("readme")},
+		"other/c.go":    {Data: []byte("package other\n")},
+	}
+
+	tools := New()
+	tools.SetFS(fsys)
+	tools.SetOverlay(map[string][]byte{
+		"pkg/b.go": []byte("package pkg\n\nvar unsaved = 1\n"),
+		"pkg/c.go": []byte("package pkg\n\nvar added = 2\n"),
+	})
+
+	if err := tools.AddDir("pkg"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := sortedFiles(tools)
+	want := []string{"pkg/a.go", "pkg/b.go", "pkg/c.go"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Wanted files %v got %v", want, got)
+	}
+
+	wantSource(t, tools, "pkg/b.go", "package pkg\n\nvar unsaved = 1\n")
+	if err := tools.OrganizeAll(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "pkg/a.go", "package pkg\n\nfunc a() {}\n\nfunc b() {}\n")
+}
+
+func TestAddOverlay(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package foo\n"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tools := New()
+	tools.SetOverlay(map[string][]byte{
+		filepath.Join(dir, "a.go"): []byte("package foo\n\nvar dirty = 1\n"),
+		filepath.Join(dir, "b.go"): []byte("package foo\n\nvar unsaved = 2\n"),
+	})
+
+	if err := tools.AddDir(dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := sortedFiles(tools)
+	if len(got) != 2 {
+		t.Fatalf("Wanted 2 files got %v", got)
+	}
+	wantSource(t, tools, filepath.Join(dir, "a.go"), "package foo\n\nvar dirty = 1\n")
+	wantSource(t, tools, filepath.Join(dir, "b.go"), "package foo\n\nvar unsaved = 2\n")
+}
@@ -0,0 +1,429 @@
+package tools
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// assumedPackageName returns the package name that an import path is
+// expected to declare when no explicit name is given in the import
+// spec, ie: "gopkg.in/yaml.v2" is assumed to be package yaml
+func assumedPackageName(path string) string {
+	notIdent := func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r >= 0x80)
+	}
+
+	base := path[strings.LastIndex(path, "/")+1:]
+	if strings.HasPrefix(base, "v") && len(base) > 1 && strings.Trim(base[1:], "0123456789") == "" {
+		// major version suffix, use the element before it
+		if i := strings.LastIndex(path, "/"); i > 0 {
+			base = path[:i]
+			base = base[strings.LastIndex(base, "/")+1:]
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, notIdent); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// importPath returns the unquoted path of the import spec
+func importPath(spec *dst.ImportSpec) string {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return spec.Path.Value
+	}
+	return path
+}
+
+// importName returns the name that the import spec makes
+// available within the file
+func importName(spec *dst.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	return assumedPackageName(importPath(spec))
+}
+
+// findImport returns the spec in the file that imports path, or nil
+// if the path is not imported
+func findImport(file *dst.File, path string) *dst.ImportSpec {
+	for _, decl := range file.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			for _, spec := range gd.Specs {
+				if is := spec.(*dst.ImportSpec); importPath(is) == path {
+					return is
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addImport adds an import of path to the file, if one does not already
+// exist, and returns the name the package can be referenced by.  If name
+// is empty the package is imported without an explicit name
+func addImport(file *dst.File, name, path string) string {
+	if spec := findImport(file, path); spec != nil {
+		return importName(spec)
+	}
+
+	spec := &dst.ImportSpec{
+		Path: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)},
+	}
+	if name != "" && name != assumedPackageName(path) {
+		spec.Name = dst.NewIdent(name)
+	}
+
+	var decl *dst.GenDecl
+	for _, d := range file.Decls {
+		if gd, ok := d.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			decl = gd
+			break
+		}
+	}
+
+	if decl == nil {
+		decl = &dst.GenDecl{Tok: token.IMPORT}
+		decl.Decs.Before = dst.EmptyLine
+		decl.Decs.After = dst.EmptyLine
+		file.Decls = append([]dst.Decl{decl}, file.Decls...)
+	}
+	decl.Specs = append(decl.Specs, spec)
+	if len(decl.Specs) > 1 {
+		decl.Lparen = true
+		decl.Rparen = true
+		for _, s := range decl.Specs {
+			s.Decorations().Before = dst.NewLine
+			s.Decorations().After = dst.NewLine
+		}
+	}
+	file.Imports = append(file.Imports, spec)
+	return importName(spec)
+}
+
+// removeImport deletes the import of path from the file.  Import
+// declarations that become empty are removed entirely
+func removeImport(file *dst.File, path string) {
+	decls := file.Decls[:0]
+	for _, decl := range file.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			specs := gd.Specs[:0]
+			for _, spec := range gd.Specs {
+				if importPath(spec.(*dst.ImportSpec)) != path {
+					specs = append(specs, spec)
+				}
+			}
+			gd.Specs = specs
+			if len(gd.Specs) == 0 {
+				continue
+			}
+			if len(gd.Specs) == 1 && len(gd.Specs[0].Decorations().Start) == 0 && len(gd.Specs[0].Decorations().End) == 0 {
+				gd.Lparen = false
+				gd.Rparen = false
+				gd.Specs[0].Decorations().Before = dst.None
+				gd.Specs[0].Decorations().After = dst.None
+			}
+		}
+		decls = append(decls, decl)
+	}
+	file.Decls = decls
+
+	imports := file.Imports[:0]
+	for _, spec := range file.Imports {
+		if importPath(spec) != path {
+			imports = append(imports, spec)
+		}
+	}
+	file.Imports = imports
+}
+
+// referencedPackages returns the set of package names that are used as
+// qualifiers in the file. Local identifiers that shadow a package name
+// are resolved by the parser and are not counted
+func referencedPackages(file *dst.File) map[string]bool {
+	names := make(map[string]bool)
+	dst.Inspect(file, func(n dst.Node) bool {
+		if sel, ok := n.(*dst.SelectorExpr); ok {
+			if id, ok := sel.X.(*dst.Ident); ok && id.Obj == nil {
+				names[id.Name] = true
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// pruneImport removes the import of path from the file if the package
+// is no longer referenced.  The name is the package name declared by
+// path, which is used when the import spec does not name the package
+// explicitly.  Blank and dot imports are never removed since their use
+// can not be determined syntactically
+func pruneImport(file *dst.File, path, name string) {
+	spec := findImport(file, path)
+	if spec == nil {
+		return
+	}
+
+	if spec.Name != nil {
+		name = spec.Name.Name
+	}
+
+	if name != "_" && name != "." && !referencedPackages(file)[name] {
+		removeImport(file, path)
+	}
+}
+
+// ImportPlacement selects where Organize places named or blank imports
+type ImportPlacement int
+
+const (
+	// ImportInGroup sorts the import by path with the other imports of
+	// its group
+	ImportInGroup ImportPlacement = iota
+
+	// ImportGroupEnd places the import after the other imports of its
+	// group
+	ImportGroupEnd
+
+	// ImportSection places the import in a section of its own after the
+	// groups
+	ImportSection
+)
+
+// ImportRules configures how Organize arranges the imports of a file.
+// The imports are merged into a single declaration and grouped into
+// sections for the standard library, third party packages and the
+// packages of the local organization, in that order
+type ImportRules struct {
+	// LocalPrefix is a comma separated list of the import path prefixes
+	// of the local organization's packages
+	LocalPrefix string
+
+	// Named is the placement of imports that name their package
+	Named ImportPlacement
+
+	// Blank is the placement of blank imports
+	Blank ImportPlacement
+}
+
+// importGroup classifies an import path as belonging to the standard
+// library (0), a third party (1) or the local organization (2).  Paths
+// are local when they start with one of the comma separated prefixes
+// of localPrefix
+func importGroup(path, localPrefix string) int {
+	for _, prefix := range strings.Split(localPrefix, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			return 2
+		}
+	}
+
+	if !strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+		return 0
+	}
+	return 1
+}
+
+// section returns the section of the import block that the spec belongs
+// to and its rank within the section.  Imports placed at the end of
+// their group are ranked after the other imports of the group
+func (rules ImportRules) section(spec *dst.ImportSpec) (section, rank int) {
+	section = importGroup(importPath(spec), rules.LocalPrefix)
+	placement := ImportInGroup
+	if spec.Name != nil && spec.Name.Name == "_" {
+		placement = rules.Blank
+		if placement == ImportSection {
+			section = 4
+		}
+	} else if spec.Name != nil {
+		placement = rules.Named
+		if placement == ImportSection {
+			section = 3
+		}
+	}
+
+	if placement == ImportGroupEnd {
+		rank = 1
+	}
+	return section, rank
+}
+
+// insertImport adds an import of path to the file in the group, of the
+// first import declaration, that it belongs to.  A new group is started
+// if the declaration has no imports of the same kind
+func insertImport(file *dst.File, name, path, localPrefix string) {
+	var decl *dst.GenDecl
+	for _, d := range file.Decls {
+		if gd, ok := d.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			decl = gd
+			break
+		}
+	}
+
+	if decl == nil || len(decl.Specs) == 0 {
+		addImport(file, name, path)
+		return
+	}
+
+	spec := &dst.ImportSpec{
+		Path: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)},
+	}
+	if name != "" && name != assumedPackageName(path) {
+		spec.Name = dst.NewIdent(name)
+	}
+
+	group := importGroup(path, localPrefix)
+	index := len(decl.Specs)
+	newGroup := true
+	for i, s := range decl.Specs {
+		g := importGroup(importPath(s.(*dst.ImportSpec)), localPrefix)
+		if g == group {
+			newGroup = false
+			if importPath(s.(*dst.ImportSpec)) > path {
+				index = i
+				break
+			}
+			index = i + 1
+		} else if g > group && newGroup {
+			index = i
+			break
+		}
+	}
+
+	spec.Decs.Before = dst.NewLine
+	spec.Decs.After = dst.NewLine
+	if newGroup && index < len(decl.Specs) {
+		decl.Specs[index].Decorations().Before = dst.EmptyLine
+	} else if newGroup && index > 0 {
+		spec.Decs.Before = dst.EmptyLine
+	} else if index < len(decl.Specs) && decl.Specs[index].Decorations().Before == dst.EmptyLine {
+		// keep the new spec at the start of its group
+		spec.Decs.Before = dst.EmptyLine
+		decl.Specs[index].Decorations().Before = dst.NewLine
+	}
+
+	decl.Specs = append(decl.Specs[:index], append([]dst.Spec{spec}, decl.Specs[index:]...)...)
+	decl.Lparen = true
+	decl.Rparen = true
+	for _, s := range decl.Specs {
+		if s.Decorations().Before == dst.None {
+			s.Decorations().Before = dst.NewLine
+		}
+		s.Decorations().After = dst.NewLine
+	}
+	file.Imports = append(file.Imports, spec)
+}
+
+// declaredNames returns the package level names declared by the files
+func declaredNames(files ...*dst.File) map[string]bool {
+	declared := make(map[string]bool)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*dst.FuncDecl); !ok || fn.Recv == nil {
+				for _, id := range declNames(decl) {
+					declared[id.Name] = true
+				}
+			}
+		}
+	}
+	return declared
+}
+
+// fixImports adds the imports that the file is missing and removes the
+// ones it does not use.  Missing packages are resolved, using the index,
+// from the standard library and the module containing dir.  The declared
+// names are the package level names of the package, which are never
+// imported.  Blank, dot and cgo imports are left alone
+func fixImports(file *dst.File, dir string, declared map[string]bool, localPrefix string, index *importIndex) {
+	buf := &bytes.Buffer{}
+	if err := decorator.Fprint(buf, file); err != nil {
+		return
+	}
+
+	afile, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), 0)
+	if err != nil {
+		return
+	}
+
+	unresolved := make(map[*ast.Ident]bool)
+	for _, id := range afile.Unresolved {
+		unresolved[id] = true
+	}
+
+	refs := make(map[string]map[string]bool)
+	ast.Inspect(afile, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && unresolved[id] && !declared[id.Name] && types.Universe.Lookup(id.Name) == nil {
+				if refs[id.Name] == nil {
+					refs[id.Name] = make(map[string]bool)
+				}
+				refs[id.Name][sel.Sel.Name] = true
+			}
+		}
+		return true
+	})
+
+	imported := make(map[string]bool)
+	for _, spec := range append([]*dst.ImportSpec{}, file.Imports...) {
+		path := importPath(spec)
+		name := importName(spec)
+		if name == "." || path == "C" {
+			// the names these imports provide can't be determined
+			return
+		} else if name == "_" {
+			continue
+		}
+
+		if refs[name] == nil && spec.Name == nil {
+			// the package may not declare the name that its path suggests,
+			// imports of packages that can't be found are kept
+			name = index.resolvePackageName(dir, path)
+			if name == "" {
+				continue
+			}
+		}
+
+		if refs[name] == nil {
+			removeImport(file, path)
+		}
+		imported[name] = true
+	}
+
+	names := []string{}
+	for name := range refs {
+		if !imported[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		// names may be declared by files of the package that are not
+		// part of the file set
+		siblings := siblingNames(dir, file.Name.Name)
+		filtered := names[:0]
+		for _, name := range names {
+			if !siblings[name] {
+				filtered = append(filtered, name)
+			}
+		}
+		names = filtered
+	}
+
+	for _, name := range names {
+		if path := index.resolveImport(dir, name, refs[name]); path != "" {
+			insertImport(file, name, path, localPrefix)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"testing"
+)
+
+func TestImportIndexLazy(t *testing.T) {
+	input := `package foo
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("x")
+}
+`
+
+	tools := New()
+	if err := tools.Add("foo.go", []byte(input)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := tools.format("foo.go", func() {}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if tools.index.stdlib != nil || len(tools.index.modules) > 0 {
+		t.Errorf("Expected the packages to only be indexed for missing imports")
+	}
+
+	if err := tools.Add("bar.go", []byte("package foo\n\nvar s = strings.ToUpper(\"s\")\n")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := tools.format("bar.go", func() {}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if tools.index.stdlib == nil {
+		t.Errorf("Expected the standard library to be indexed")
+	}
+}
+
+func TestReplaceImports(t *testing.T) {
+	input := `package foo
+
+func greet() {}
+`
+	want := `package foo
+
+import "strings"
+
+func greet() {
+	println(strings.Repeat("hi", 2))
+}
+`
+
+	r := Replace("foo.go", []byte(input)).Func("greet", "", "func greet() {\nprintln(strings.Repeat(\"hi\", 2))\n}\n")
+	if r.Err != nil {
+		t.Fatalf("Unexpected error: %v", r.Err)
+	}
+
+	if got := string(r.Content()); got != want {
+		t.Errorf("Wanted\n%s\nGot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,725 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"sort"
+	"strconv"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+)
+
+// callSite is a call to the function being inlined
+type callSite struct {
+	filename string
+	call     *ast.CallExpr
+	path     []ast.Node
+}
+
+type inliner struct {
+	check  *checked
+	fn     *types.Func
+	callee *ast.FuncDecl
+	sig    *types.Signature
+}
+
+// newInliner locates the declaration of the function and makes sure
+// that it can be inlined
+func newInliner(check *checked, fn *types.Func) (*inliner, error) {
+	in := &inliner{
+		check: check,
+		fn:    fn,
+		sig:   fn.Type().(*types.Signature),
+	}
+
+	for _, file := range check.files {
+		for _, decl := range file.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && check.info.Defs[fd.Name] == fn {
+				in.callee = fd
+			}
+		}
+	}
+
+	if in.callee == nil || in.callee.Body == nil {
+		return nil, fmt.Errorf("%q: %w", fn.Name(), ErrDeclNotFound)
+	}
+
+	if in.sig.Variadic() {
+		return nil, fmt.Errorf("%w: %s is variadic", ErrUnsupported, fn.Name())
+	}
+
+	for i := 0; i < in.sig.Results().Len(); i++ {
+		if in.sig.Results().At(i).Name() != "" {
+			return nil, fmt.Errorf("%w: %s has named results", ErrUnsupported, fn.Name())
+		}
+	}
+
+	var err error
+	returns := 0
+	ast.Inspect(in.callee.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.DeferStmt, *ast.LabeledStmt:
+			err = fmt.Errorf("%w: %s uses defer or labels", ErrUnsupported, fn.Name())
+		case *ast.ReturnStmt:
+			returns++
+		case *ast.Ident:
+			if check.info.Uses[n] == fn {
+				err = fmt.Errorf("%w: %s is recursive", ErrUnsupported, fn.Name())
+			}
+		}
+		return err == nil
+	})
+
+	if err == nil && returns > 0 {
+		list := in.callee.Body.List
+		if _, ok := list[len(list)-1].(*ast.ReturnStmt); !ok || returns > 1 {
+			err = fmt.Errorf("%w: %s returns before the end of the function", ErrUnsupported, fn.Name())
+		}
+	}
+	return in, err
+}
+
+// expression determines if the function consists of nothing but a
+// single return of a single value
+func (in *inliner) expression() bool {
+	list := in.callee.Body.List
+	if len(list) == 1 && in.sig.Results().Len() == 1 {
+		ret, ok := list[0].(*ast.ReturnStmt)
+		return ok && len(ret.Results) == 1
+	}
+	return false
+}
+
+// sites returns all of the calls to the function in the package
+func (in *inliner) sites() (sites []callSite) {
+	for _, filename := range sortedFilenames(in.check.files) {
+		file := in.check.files[filename]
+		ast.Inspect(file, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok && calledFunc(in.check, call) == in.fn {
+				sites = append(sites, callSite{filename: filename, call: call, path: enclosing(file, call)})
+			}
+			return true
+		})
+	}
+	return sites
+}
+
+// impure determines if evaluating the expression could have side effects
+func (in *inliner) impure(expr ast.Expr) (impure bool) {
+	if tv, found := in.check.info.Types[expr]; found && tv.Value != nil {
+		return false
+	}
+
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			// conversions are pure
+			if tv, found := in.check.info.Types[n.Fun]; !found || !tv.IsType() {
+				impure = true
+			}
+		case *ast.UnaryExpr:
+			impure = impure || n.Op == token.ARROW
+		case *ast.FuncLit:
+			return false
+		}
+		return !impure
+	})
+	return impure
+}
+
+// binding describes how a parameter of the function receives the value
+// of its argument at a specific call site
+type binding struct {
+	param *types.Var
+	arg   ast.Expr
+	expr  dst.Expr
+	name  string
+	uses  int
+}
+
+// bindings pairs the parameters (and receiver) of the function with
+// the arguments of the call
+func (in *inliner) bindings(site callSite) ([]*binding, error) {
+	bindings := []*binding{}
+	if recv := in.sig.Recv(); recv != nil {
+		sel, ok := unparen(site.call.Fun).(*ast.SelectorExpr)
+		if !ok {
+			return nil, fmt.Errorf("%w: method value call", ErrUnsupported)
+		}
+
+		b := &binding{param: recv, arg: sel.X, expr: in.clone(sel.X)}
+		_, wantPtr := recv.Type().(*types.Pointer)
+		_, isPtr := in.check.info.TypeOf(sel.X).Underlying().(*types.Pointer)
+		if wantPtr && !isPtr {
+			b.expr = &dst.UnaryExpr{Op: token.AND, X: b.expr}
+		} else if !wantPtr && isPtr {
+			b.expr = &dst.StarExpr{X: b.expr}
+		}
+		bindings = append(bindings, b)
+	}
+
+	if len(site.call.Args) != in.sig.Params().Len() || site.call.Ellipsis.IsValid() {
+		return nil, fmt.Errorf("%w: call with multi-value argument", ErrUnsupported)
+	}
+
+	for i, arg := range site.call.Args {
+		bindings = append(bindings, &binding{param: in.sig.Params().At(i), arg: arg, expr: in.clone(arg)})
+	}
+
+	for _, b := range bindings {
+		ast.Inspect(in.callee.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && in.check.info.Uses[id] == b.param {
+				b.uses++
+			}
+			return true
+		})
+	}
+	return bindings, nil
+}
+
+// assigned determines if the variable is assigned to, or has its
+// address taken, anywhere in the body of the function
+func (in *inliner) assigned(v *types.Var) (found bool) {
+	is := func(expr ast.Expr) bool {
+		id, ok := unparen(expr).(*ast.Ident)
+		return ok && in.check.info.Uses[id] == v
+	}
+
+	ast.Inspect(in.callee.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				found = found || is(lhs)
+			}
+		case *ast.IncDecStmt:
+			found = found || is(n.X)
+		case *ast.RangeStmt:
+			found = found || (n.Key != nil && is(n.Key)) || (n.Value != nil && is(n.Value))
+		case *ast.UnaryExpr:
+			found = found || (n.Op == token.AND && is(n.X))
+		}
+		return !found
+	})
+	return found
+}
+
+// clone returns a copy of the dst node corresponding to the ast expression
+func (in *inliner) clone(expr ast.Expr) dst.Expr {
+	return dst.Clone(in.check.nodes.Dst.Nodes[expr]).(dst.Expr)
+}
+
+// names returns every identifier name used in the node
+func names(root ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	ast.Inspect(root, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			names[id.Name] = true
+		}
+		return true
+	})
+	return names
+}
+
+// body returns a copy of the function body with the parameters and
+// local variables replaced or renamed according to the bindings and the
+// names in use by the caller.  The imports that the copy requires in
+// the caller's file are returned as a map of path to name
+func (in *inliner) body(site callSite, bindings []*binding, file *dst.File) ([]dst.Stmt, map[string]string, error) {
+	orig := in.check.nodes.Dst.Nodes[in.callee.Body].(*dst.BlockStmt)
+	clone := dst.Clone(orig).(*dst.BlockStmt)
+
+	origIdents := []*dst.Ident{}
+	dst.Inspect(orig, func(n dst.Node) bool {
+		if id, ok := n.(*dst.Ident); ok {
+			origIdents = append(origIdents, id)
+		}
+		return true
+	})
+
+	cloneIdents := map[*dst.Ident]*dst.Ident{}
+	i := 0
+	dst.Inspect(clone, func(n dst.Node) bool {
+		if id, ok := n.(*dst.Ident); ok {
+			cloneIdents[origIdents[i]] = id
+			i++
+		}
+		return true
+	})
+
+	params := make(map[types.Object]*binding)
+	for _, b := range bindings {
+		params[b.param] = b
+	}
+
+	caller := site.path[1]
+	taken := names(caller)
+	for name := range names(in.callee) {
+		taken[name] = true
+	}
+
+	unique := func(name string) string {
+		candidate := name
+		for i := 2; taken[candidate]; i++ {
+			candidate = name + strconv.Itoa(i)
+		}
+		taken[candidate] = true
+		return candidate
+	}
+
+	callerNames := names(caller)
+	renamed := make(map[types.Object]string)
+	for _, b := range bindings {
+		if b.name != "" && callerNames[b.name] {
+			renamed[b.param] = unique(b.name)
+			b.name = renamed[b.param]
+		}
+	}
+
+	scope := in.check.pkg.Scope().Innermost(site.call.Pos())
+	imports := make(map[string]string)
+	replace := make(map[*dst.Ident]dst.Expr)
+	var err error
+	for _, id := range origIdents {
+		aid, ok := in.check.nodes.Ast.Nodes[id].(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		clone := cloneIdents[id]
+		if obj := in.check.info.Defs[aid]; obj != nil {
+			if _, ok := obj.(*types.Var); ok && callerNames[obj.Name()] {
+				renamed[obj] = unique(obj.Name())
+			}
+			if name, found := renamed[obj]; found {
+				clone.Name = name
+			}
+			continue
+		}
+
+		obj := in.check.info.Uses[aid]
+		if b, found := params[obj]; found {
+			if b.name != "" {
+				clone.Name = b.name
+			} else {
+				replace[clone] = b.expr
+			}
+		} else if name, found := renamed[obj]; found {
+			clone.Name = name
+		} else if pn, ok := obj.(*types.PkgName); ok {
+			path := pn.Imported().Path()
+			clone.Name = pn.Imported().Name()
+			if spec := findImport(file, path); spec != nil {
+				clone.Name = importName(spec)
+			}
+			imports[path] = clone.Name
+		} else if obj != nil && (obj.Parent() == in.check.pkg.Scope() || obj.Parent() == types.Universe) && scope != nil {
+			// make sure nothing at the call site shadows the name
+			if _, found := scope.LookupParent(obj.Name(), site.call.Pos()); found != obj {
+				err = fmt.Errorf("%w: %s is shadowed at the call site", ErrUnsupported, obj.Name())
+			}
+		}
+	}
+
+	dstutil.Apply(clone, func(cursor *dstutil.Cursor) bool {
+		if id, ok := cursor.Node().(*dst.Ident); ok {
+			if expr, found := replace[id]; found {
+				cursor.Replace(parenthesize(dst.Clone(expr).(dst.Expr), cursor.Parent(), id))
+			}
+		}
+		return true
+	}, nil)
+	return clone.List, imports, err
+}
+
+// inline replaces the call at the site with the body of the function
+func (in *inliner) inline(site callSite, file *dst.File) error {
+	bindings, err := in.bindings(site)
+	if err != nil {
+		return err
+	}
+
+	parent := site.path[len(site.path)-2]
+	switch parent.(type) {
+	case *ast.DeferStmt, *ast.GoStmt:
+		return fmt.Errorf("%w: call to %s is deferred or run in a goroutine", ErrUnsupported, in.fn.Name())
+	}
+
+	if _, ok := parent.(*ast.ExprStmt); !ok && in.expression() {
+		return in.inlineExpr(site, bindings, file)
+	}
+
+	var stmt ast.Stmt
+	switch p := parent.(type) {
+	case *ast.ExprStmt:
+		stmt = p
+	case *ast.AssignStmt:
+		if len(p.Rhs) == 1 {
+			stmt = p
+		}
+	}
+
+	if stmt == nil {
+		return fmt.Errorf("%w: call to %s must be a statement or assignment", ErrUnsupported, in.fn.Name())
+	}
+
+	stmts := []dst.Stmt{}
+	for _, b := range bindings {
+		if b.uses == 0 {
+			if in.impure(b.arg) {
+				stmts = append(stmts, &dst.AssignStmt{Lhs: []dst.Expr{dst.NewIdent("_")}, Tok: token.ASSIGN, Rhs: []dst.Expr{b.expr}})
+			}
+			continue
+		}
+
+		if in.impure(b.arg) || in.assigned(b.param) || b.param.Name() == "_" {
+			b.name = b.param.Name()
+		}
+	}
+
+	body, imports, err := in.body(site, bindings, file)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bindings {
+		if b.name == "" || b.uses == 0 {
+			continue
+		}
+
+		if types.Identical(in.check.info.TypeOf(b.arg), b.param.Type()) {
+			stmts = append(stmts, &dst.AssignStmt{Lhs: []dst.Expr{dst.NewIdent(b.name)}, Tok: token.DEFINE, Rhs: []dst.Expr{b.expr}})
+		} else {
+			stmts = append(stmts, &dst.DeclStmt{Decl: &dst.GenDecl{
+				Tok:   token.VAR,
+				Specs: []dst.Spec{&dst.ValueSpec{Names: []*dst.Ident{dst.NewIdent(b.name)}, Type: in.check.typeExpr(b.param.Type()), Values: []dst.Expr{b.expr}}},
+			}})
+		}
+	}
+
+	var results []dst.Expr
+	if len(body) > 0 {
+		if ret, ok := body[len(body)-1].(*dst.ReturnStmt); ok {
+			results = ret.Results
+			body = body[:len(body)-1]
+		}
+	}
+	stmts = append(stmts, body...)
+
+	dstmt := in.check.nodes.Dst.Nodes[stmt].(dst.Stmt)
+	if as, ok := dstmt.(*dst.AssignStmt); ok {
+		stmts = append(stmts, &dst.AssignStmt{Lhs: as.Lhs, Tok: as.Tok, Rhs: results})
+	} else if len(results) > 0 {
+		for _, result := range results {
+			if r, ok := result.(*dst.CallExpr); ok {
+				stmts = append(stmts, &dst.ExprStmt{X: r})
+			}
+		}
+	}
+
+	if len(stmts) == 0 {
+		stmts = append(stmts, &dst.EmptyStmt{Implicit: true})
+	}
+
+	for _, s := range stmts {
+		s.Decorations().Before = dst.NewLine
+		s.Decorations().After = dst.NewLine
+	}
+	stmts[0].Decorations().Start = dstmt.Decorations().Start
+	stmts[0].Decorations().Before = dstmt.Decorations().Before
+	stmts[len(stmts)-1].Decorations().End = dstmt.Decorations().End
+	stmts[len(stmts)-1].Decorations().After = dstmt.Decorations().After
+
+	owner := site.path[len(site.path)-3]
+	var list *[]dst.Stmt
+	switch n := in.check.nodes.Dst.Nodes[owner].(type) {
+	case *dst.BlockStmt:
+		list = &n.List
+	case *dst.CaseClause:
+		list = &n.Body
+	case *dst.CommClause:
+		list = &n.Body
+	default:
+		return fmt.Errorf("%w: call to %s is not part of a statement list", ErrUnsupported, in.fn.Name())
+	}
+
+	for i, s := range *list {
+		if s == dstmt {
+			*list = append((*list)[:i], append(stmts, (*list)[i+1:]...)...)
+			break
+		}
+	}
+
+	for path, name := range imports {
+		addImport(file, name, path)
+	}
+	return nil
+}
+
+// inlineExpr replaces the call with the expression that the
+// function returns
+func (in *inliner) inlineExpr(site callSite, bindings []*binding, file *dst.File) error {
+	impure := 0
+	for _, b := range bindings {
+		if in.impure(b.arg) {
+			impure++
+			if b.uses != 1 {
+				return fmt.Errorf("%w: argument %s of %s must be evaluated exactly once", ErrUnsupported, b.param.Name(), in.fn.Name())
+			}
+		}
+	}
+
+	if impure > 1 {
+		return fmt.Errorf("%w: inlining %s would change the evaluation order of its arguments", ErrUnsupported, in.fn.Name())
+	}
+
+	body, imports, err := in.body(site, bindings, file)
+	if err != nil {
+		return err
+	}
+
+	expr := body[0].(*dst.ReturnStmt).Results[0]
+	call := in.check.nodes.Dst.Nodes[site.call].(*dst.CallExpr)
+	expr.Decorations().Start = call.Decs.Start
+	expr.Decorations().End = call.Decs.End
+	parent := in.check.nodes.Dst.Nodes[site.path[len(site.path)-2]]
+	expr = parenthesize(expr, parent, call)
+
+	replaced := false
+	dstutil.Apply(parent, func(cursor *dstutil.Cursor) bool {
+		if cursor.Node() == call && !replaced {
+			cursor.Replace(expr)
+			replaced = true
+			return false
+		}
+		return !replaced
+	}, nil)
+
+	for path, name := range imports {
+		addImport(file, name, path)
+	}
+	return nil
+}
+
+// calledFunc returns the package function or method that the call
+// invokes, or nil if it does not statically call a function declared
+// in the checked package
+func calledFunc(check *checked, call *ast.CallExpr) *types.Func {
+	var id *ast.Ident
+	switch fun := unparen(call.Fun).(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		if sel := check.info.Selections[fun]; sel != nil && sel.Kind() != types.MethodVal {
+			return nil
+		}
+		id = fun.Sel
+	}
+
+	if id != nil {
+		if fn, ok := check.info.Uses[id].(*types.Func); ok && fn.Pkg() == check.pkg {
+			if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+				if _, ok := recv.Type().Underlying().(*types.Interface); ok {
+					return nil
+				}
+			}
+			return fn
+		}
+	}
+	return nil
+}
+
+// lookupFunc finds the package function called name or, if receiver
+// is not empty, the method of the named receiver type
+func lookupFunc(check *checked, name, receiver string) (fn *types.Func) {
+	if receiver == "" {
+		fn, _ = check.pkg.Scope().Lookup(name).(*types.Func)
+	} else if tn, ok := check.pkg.Scope().Lookup(receiver).(*types.TypeName); ok {
+		obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(tn.Type()), false, check.pkg, name)
+		fn, _ = obj.(*types.Func)
+	}
+	return fn
+}
+
+// parenthesize wraps the expression in parentheses if it is needed
+// to replace the operand old of the parent node
+func parenthesize(expr dst.Expr, parent dst.Node, old dst.Expr) dst.Expr {
+	prec := token.HighestPrec
+	switch e := expr.(type) {
+	case *dst.BinaryExpr:
+		prec = e.Op.Precedence()
+	case *dst.UnaryExpr, *dst.StarExpr:
+		prec = token.UnaryPrec
+	case *dst.KeyValueExpr:
+		prec = token.LowestPrec
+	}
+
+	needed := false
+	switch p := parent.(type) {
+	case *dst.BinaryExpr:
+		op := p.Op.Precedence()
+		needed = prec < op || (prec == op && p.Y == old)
+	case *dst.UnaryExpr, *dst.StarExpr:
+		needed = prec < token.HighestPrec
+	case *dst.SelectorExpr, *dst.IndexExpr, *dst.SliceExpr, *dst.TypeAssertExpr:
+		needed = prec < token.HighestPrec
+	case *dst.CallExpr:
+		needed = p.Fun == old && prec < token.HighestPrec
+	}
+
+	if needed {
+		return &dst.ParenExpr{X: expr}
+	}
+	return expr
+}
+
+// sortedFilenames returns the keys of the map in lexical order
+func sortedFilenames(files map[string]*ast.File) []string {
+	filenames := []string{}
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// Inline replaces every call to the function called name, or to the
+// method of the receiver type, with the body of the function.  Unexported
+// functions that are no longer referenced are deleted.  If any call can't
+// be inlined the files are left unchanged
+func (f *Tools) Inline(name, receiver string) error {
+	defer f.operation()()
+	starts := f.snapshot()
+	err := f.inline(name, receiver)
+	if err != nil {
+		// restore the calls inlined before the one that failed
+		for filename, content := range starts {
+			f.parse(filename, content)
+		}
+		return err
+	}
+	return f.recordAll(starts)
+}
+
+func (f *Tools) inline(name, receiver string) error {
+	check := f.edit()
+	fn := lookupFunc(check, name, receiver)
+	if fn == nil {
+		return fmt.Errorf("%q: %w", name, ErrDeclNotFound)
+	}
+
+	// inline one call at a time so that nested calls and calls that
+	// share a statement list always see an up to date view
+	for {
+		in, err := newInliner(check, fn)
+		if err != nil {
+			return err
+		}
+
+		sites := in.sites()
+		if len(sites) == 0 {
+			break
+		}
+
+		site := sites[0]
+		if err := in.inline(site, f.dfiles[site.filename]); err != nil {
+			return err
+		}
+		check = f.edit()
+		fn = lookupFunc(check, name, receiver)
+	}
+
+	if !fn.Exported() && receiver == "" {
+		f.deleteFunc(check, fn)
+	}
+	return nil
+}
+
+// deleteFunc removes the declaration of the function if nothing
+// refers to it any longer
+func (f *Tools) deleteFunc(check *checked, fn *types.Func) {
+	for _, obj := range check.info.Uses {
+		if obj == fn {
+			return
+		}
+	}
+
+	for filename, file := range check.files {
+		for _, decl := range file.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && check.info.Defs[fd.Name] == fn {
+				dfile := f.dfiles[filename]
+				ddecl := check.nodes.Dst.Nodes[fd]
+				decls := dfile.Decls[:0]
+				for _, d := range dfile.Decls {
+					if d != ddecl {
+						decls = append(decls, d)
+					}
+				}
+				dfile.Decls = decls
+			}
+		}
+	}
+}
+
+// InlineCall replaces the function call at the given position of the
+// file with the body of the called function.  Only the Line and Column
+// fields of the position are used.  If Column is zero, the first call on
+// the line is inlined
+func (f *Tools) InlineCall(filename string, pos token.Position) ([]byte, error) {
+	defer f.operation()()
+	if _, found := f.dfiles[filename]; !found {
+		return nil, fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
+	}
+
+	check := f.edit()
+	file := check.files[filename]
+	tf := check.fset.File(file.Pos())
+	if pos.Line < 1 || pos.Line > tf.LineCount() {
+		return nil, fmt.Errorf("%w: line %d is outside of the file", ErrInvalidRange, pos.Line)
+	}
+
+	start := tf.LineStart(pos.Line)
+	end := start + token.Pos(pos.Column)
+	if pos.Column == 0 {
+		if pos.Line < tf.LineCount() {
+			end = tf.LineStart(pos.Line + 1)
+		} else {
+			end = token.Pos(tf.Base() + tf.Size())
+		}
+	} else {
+		start = end - 1
+	}
+
+	var call *ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if c, ok := n.(*ast.CallExpr); ok && calledFunc(check, c) != nil {
+			if (pos.Column == 0 && call == nil && start <= c.Pos() && c.Pos() < end) || (pos.Column > 0 && c.Pos() <= start && start < c.End()) {
+				call = c
+			}
+		}
+		return true
+	})
+
+	if call == nil {
+		return nil, fmt.Errorf("%w: no call to a package function at %d:%d", ErrInvalidRange, pos.Line, pos.Column)
+	}
+
+	in, err := newInliner(check, calledFunc(check, call))
+	if err != nil {
+		return nil, err
+	}
+
+	output, ferr := f.format(filename, func() {
+		err = in.inline(callSite{filename: filename, call: call, path: enclosing(file, call)}, f.dfiles[filename])
+	})
+
+	if err == nil {
+		err = ferr
+	}
+	return output, err
+}
@@ -0,0 +1,274 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"sort"
+
+	"github.com/dave/dst"
+)
+
+// interfaceExtractor builds an interface from the methods of a named
+// type and finds the parameters that can accept the interface in place
+// of the type
+type interfaceExtractor struct {
+	check   *checked
+	named   *types.Named
+	name    string
+	methods []*types.Func
+}
+
+// collect looks up the requested methods of the type.  When no methods
+// are requested every exported method is used
+func (ie *interfaceExtractor) collect(names []string) error {
+	mset := types.NewMethodSet(types.NewPointer(ie.named))
+	if len(names) == 0 {
+		for i := 0; i < mset.Len(); i++ {
+			if fn := mset.At(i).Obj().(*types.Func); fn.Exported() {
+				ie.methods = append(ie.methods, fn)
+			}
+		}
+	}
+
+	for _, name := range names {
+		sel := mset.Lookup(ie.check.pkg, name)
+		if sel == nil {
+			return fmt.Errorf("%q: %w", name, ErrDeclNotFound)
+		}
+		ie.methods = append(ie.methods, sel.Obj().(*types.Func))
+	}
+
+	if len(ie.methods) == 0 {
+		return fmt.Errorf("%w: %s has no exported methods", ErrUnsupported, ie.named.Obj().Name())
+	}
+
+	sort.Slice(ie.methods, func(i, j int) bool { return ie.methods[i].Name() < ie.methods[j].Name() })
+	return nil
+}
+
+// declaration generates the interface type declaration.  The doc
+// comments of the methods declared in the package are carried over
+// to the interface
+func (ie *interfaceExtractor) declaration(file *dst.File, docs map[string]dst.Decorations) *dst.GenDecl {
+	qualifier := func(pkg *types.Package) string {
+		if pkg == ie.check.pkg {
+			return ""
+		}
+		return addImport(file, pkg.Name(), pkg.Path())
+	}
+
+	methods := &dst.FieldList{Opening: true, Closing: true}
+	for _, m := range ie.methods {
+		ft := parseExpr(types.TypeString(m.Type(), qualifier)).(*dst.FuncType)
+		ft.Func = false
+		field := &dst.Field{
+			Names: []*dst.Ident{dst.NewIdent(m.Name())},
+			Type:  ft,
+		}
+		field.Decs.Before = dst.NewLine
+		field.Decs.After = dst.NewLine
+		field.Decs.Start = append(field.Decs.Start, docs[m.Name()]...)
+		methods.List = append(methods.List, field)
+	}
+
+	decl := &dst.GenDecl{
+		Tok: token.TYPE,
+		Specs: []dst.Spec{&dst.TypeSpec{
+			Name: dst.NewIdent(ie.name),
+			Type: &dst.InterfaceType{Methods: methods},
+		}},
+	}
+	return decl
+}
+
+// docs returns the doc comments of the type's method declarations
+func (ie *interfaceExtractor) docs() map[string]dst.Decorations {
+	docs := make(map[string]dst.Decorations)
+	for _, file := range ie.check.files {
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil {
+				continue
+			}
+
+			if fn, ok := ie.check.info.Defs[fd.Name].(*types.Func); ok && ie.includes(fn) {
+				ddecl := ie.check.nodes.Dst.Nodes[fd].(*dst.FuncDecl)
+				docs[fn.Name()] = append(dst.Decorations{}, ddecl.Decs.Start...)
+			}
+		}
+	}
+	return docs
+}
+
+// includes determines if the method is one of the interface's methods
+func (ie *interfaceExtractor) includes(fn *types.Func) bool {
+	for _, m := range ie.methods {
+		if m == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// implements determines if values of the type have every method of
+// the interface in their method set
+func (ie *interfaceExtractor) implements(t types.Type) bool {
+	mset := types.NewMethodSet(t)
+	for _, m := range ie.methods {
+		if mset.Lookup(ie.check.pkg, m.Name()) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// accepts determines if every use of the parameter in the function
+// body calls, or takes the value of, one of the interface's methods
+func (ie *interfaceExtractor) accepts(param types.Object, body *ast.BlockStmt) bool {
+	parents := make(map[ast.Node]ast.Node)
+	stack := []ast.Node{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return false
+		}
+
+		if len(stack) > 0 {
+			parents[n] = stack[len(stack)-1]
+		}
+		stack = append(stack, n)
+		return true
+	})
+
+	for id, obj := range ie.check.info.Uses {
+		if obj != param {
+			continue
+		}
+
+		sel, ok := parents[id].(*ast.SelectorExpr)
+		if !ok || sel.X != id {
+			return false
+		}
+
+		fn, ok := ie.check.info.Uses[sel.Sel].(*types.Func)
+		if !ok || !ie.includes(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// called determines if every use of the function calls it, rather than
+// using it as a value whose type must not change
+func (ie *interfaceExtractor) called(fn types.Object) bool {
+	callees := make(map[*ast.Ident]bool)
+	for _, file := range ie.check.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if id, ok := unparen(call.Fun).(*ast.Ident); ok {
+					callees[id] = true
+				}
+			}
+			return true
+		})
+	}
+
+	for id, obj := range ie.check.info.Uses {
+		if obj == fn && !callees[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// parameters returns the parameter fields of the package's functions
+// whose type is the concrete type, or a pointer to it, and whose names
+// are only used to call methods of the interface.  Only functions that
+// are called directly are included, since the type of methods, function
+// literals and functions used as values can not change
+func (ie *interfaceExtractor) parameters() (fields []*ast.Field) {
+	for _, filename := range sortedFilenames(ie.check.files) {
+		for _, decl := range ie.check.files[filename].Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Body == nil || !ie.called(ie.check.info.Defs[fn.Name]) {
+				continue
+			}
+
+			for _, field := range fn.Type.Params.List {
+				t := ie.check.info.TypeOf(field.Type)
+				if t == nil || len(field.Names) == 0 || !ie.implements(t) {
+					continue
+				}
+
+				if ptr, ok := t.(*types.Pointer); ok {
+					t = ptr.Elem()
+				}
+
+				if t != ie.named {
+					continue
+				}
+
+				accepts := true
+				for _, name := range field.Names {
+					accepts = accepts && ie.accepts(ie.check.info.Defs[name], fn.Body)
+				}
+
+				if accepts {
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+	return fields
+}
+
+// ExtractInterface declares an interface called iface made up of the
+// given methods of the type, or all of its exported methods, in filename
+// or the type's file.  If rewrite is true, parameters of the type that
+// only call the interface's methods are changed to the interface
+func (f *Tools) ExtractInterface(typ, iface, filename string, rewrite bool, methods ...string) error {
+	defer f.operation()()
+	check := f.edit()
+	obj, ok := check.pkg.Scope().Lookup(typ).(*types.TypeName)
+	ie := &interfaceExtractor{check: check, name: iface}
+	if ok {
+		ie.named, ok = obj.Type().(*types.Named)
+	}
+
+	if !ok || types.IsInterface(ie.named) {
+		return fmt.Errorf("%w: type %s", ErrDeclNotFound, typ)
+	}
+
+	if check.pkg.Scope().Lookup(iface) != nil {
+		return fmt.Errorf("%q: %w", iface, ErrDeclExists)
+	}
+
+	if filename == "" {
+		filename = check.fset.File(obj.Pos()).Name()
+	} else if _, found := f.dfiles[filename]; !found {
+		return fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
+	}
+
+	if err := ie.collect(methods); err != nil {
+		return err
+	}
+
+	fields := []*ast.Field{}
+	if rewrite {
+		fields = ie.parameters()
+	}
+
+	starts := f.snapshot()
+	file := f.dfiles[filename]
+	o := &organizer{file: file}
+	o.insert(ie.declaration(file, ie.docs()))
+
+	for _, field := range fields {
+		check.nodes.Dst.Nodes[field].(*dst.Field).Type = dst.NewIdent(iface)
+	}
+
+	return f.recordAll(starts)
+}
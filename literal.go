@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"go/ast"
+	"go/types"
+
+	"github.com/dave/dst"
+)
+
+// literalName returns the name used to select the literal's type.
+// Types declared in the checked package are named by their type name
+// and imported types by their import path and type name, ie:
+// "net/http.Cookie".  Unnamed types have an empty name
+func (c *checked) literalName(t types.Type) string {
+	if named, ok := t.(*types.Named); ok {
+		obj := named.Obj()
+		if obj.Pkg() == nil || obj.Pkg() == c.pkg {
+			return obj.Name()
+		}
+		return obj.Pkg().Path() + "." + obj.Name()
+	}
+	return ""
+}
+
+// keyLiteral converts the unkeyed struct literal to a keyed one.  The
+// decorations of each element are moved to its key so comments stay
+// where they were
+func keyLiteral(lit *dst.CompositeLit, st *types.Struct) {
+	for i, elt := range lit.Elts {
+		kv := &dst.KeyValueExpr{
+			Key:   dst.NewIdent(st.Field(i).Name()),
+			Value: elt,
+		}
+		kv.Decs.NodeDecs = *elt.Decorations()
+		*elt.Decorations() = dst.NodeDecs{}
+		lit.Elts[i] = kv
+	}
+}
+
+// KeyLiterals converts the unkeyed struct literals of the package, or
+// only those of the named types, ie: "Point" or "image.Point", to keyed
+// literals
+func (f *Tools) KeyLiterals(names ...string) error {
+	defer f.operation()()
+	check := f.edit()
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	starts := f.snapshot()
+	for _, file := range check.files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok || len(lit.Elts) == 0 {
+				return true
+			}
+
+			if _, keyed := lit.Elts[0].(*ast.KeyValueExpr); keyed {
+				return true
+			}
+
+			t := check.info.TypeOf(lit)
+			if t == nil || (len(names) > 0 && !selected[check.literalName(t)]) {
+				return true
+			}
+
+			if st, ok := t.Underlying().(*types.Struct); ok && st.NumFields() == len(lit.Elts) {
+				keyLiteral(check.nodes.Dst.Nodes[lit].(*dst.CompositeLit), st)
+			}
+			return true
+		})
+	}
+
+	return f.recordAll(starts)
+}
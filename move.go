@@ -0,0 +1,572 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"sort"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+)
+
+// movedDecl is a declaration that is being moved to another package.
+// If the declaration was extracted from a parenthesized block then
+// parent is the block it is removed from
+type movedDecl struct {
+	filename string
+	decl     dst.Decl
+	parent   *dst.GenDecl
+}
+
+type mover struct {
+	src        *Tools
+	target     *Tools
+	targetFile string
+	importers  []*Tools
+	name       string
+	rename     string
+
+	check    *checked
+	decls    []movedDecl
+	moved    map[types.Object]bool
+	names    map[string]string
+	srcRefs  map[*dst.Ident]bool
+	tgtRefs  map[*dst.Ident]bool
+	tgtQuals map[*dst.SelectorExpr]bool
+	imports  map[string]string
+	specs    map[dst.Spec]bool
+}
+
+// collect finds the declaration named m.name along with, if it is a
+// type, the methods and constructors that the organizer would group
+// with it
+func (m *mover) collect() error {
+	obj := m.check.pkg.Scope().Lookup(m.name)
+	if obj == nil {
+		return fmt.Errorf("%q: %w", m.name, ErrDeclNotFound)
+	}
+	_, isType := obj.(*types.TypeName)
+
+	for _, filename := range sortedFiles(m.src) {
+		for _, decl := range m.src.dfiles[filename].Decls {
+			switch d := decl.(type) {
+			case *dst.FuncDecl:
+				if m.belongs(d, isType) {
+					m.decls = append(m.decls, movedDecl{filename: filename, decl: d})
+				}
+			case *dst.GenDecl:
+				moved, err := m.extract(d)
+				if err != nil {
+					return err
+				}
+
+				if moved != nil {
+					md := movedDecl{filename: filename, decl: moved}
+					if moved != d {
+						md.parent = d
+					}
+					m.decls = append(m.decls, md)
+				}
+			}
+		}
+	}
+
+	m.moved = make(map[types.Object]bool)
+	m.names = make(map[string]string)
+	m.specs = make(map[dst.Spec]bool)
+	for _, md := range m.decls {
+		if md.parent != nil {
+			m.specs[md.decl.(*dst.GenDecl).Specs[0]] = true
+		}
+
+		for _, id := range declNames(md.decl) {
+			if obj := m.check.object(id); obj != nil {
+				m.moved[obj] = true
+				if fn, ok := md.decl.(*dst.FuncDecl); !ok || fn.Recv == nil {
+					m.names[id.Name] = id.Name
+				}
+			}
+		}
+	}
+
+	if m.rename != "" {
+		m.names[m.name] = m.rename
+	}
+	return nil
+}
+
+// belongs determines if the function is part of the move, either by
+// name, or by being a method or constructor of the moved type
+func (m *mover) belongs(fn *dst.FuncDecl, isType bool) bool {
+	if fn.Recv != nil {
+		return isType && typStr(fn.Recv.List[0].Type) == m.name
+	}
+
+	if fn.Name.Name == m.name {
+		return true
+	}
+
+	if isType && fn.Type.Results != nil {
+		for _, result := range fn.Type.Results.List {
+			if typStr(result.Type) == m.name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extract returns the part of the generic declaration that declares
+// m.name.  If the name is declared in a parenthesized block with other
+// specs then the spec is placed in a new declaration
+func (m *mover) extract(decl *dst.GenDecl) (*dst.GenDecl, error) {
+	for _, spec := range decl.Specs {
+		found := false
+		switch s := spec.(type) {
+		case *dst.TypeSpec:
+			found = s.Name.Name == m.name
+		case *dst.ValueSpec:
+			for _, id := range s.Names {
+				if id.Name == m.name {
+					found = true
+				}
+			}
+
+			if found && len(decl.Specs) > 1 {
+				if len(s.Names) > 1 || len(s.Values) == 0 || (decl.Tok == token.CONST && usesIota(s)) {
+					return nil, fmt.Errorf("%w: %q is part of a const or var block that can not be split", ErrUnsupported, m.name)
+				}
+			} else if found && len(s.Names) > 1 {
+				return nil, fmt.Errorf("%w: %q is declared along with other names", ErrUnsupported, m.name)
+			}
+		}
+
+		if found {
+			if len(decl.Specs) == 1 {
+				return decl, nil
+			}
+			return &dst.GenDecl{Tok: decl.Tok, Specs: []dst.Spec{spec}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// analyze walks the moved and remaining declarations to determine
+// which references need to be rewritten and whether the move would
+// be legal
+func (m *mover) analyze() (err error) {
+	m.srcRefs = make(map[*dst.Ident]bool)
+	m.tgtRefs = make(map[*dst.Ident]bool)
+	m.tgtQuals = make(map[*dst.SelectorExpr]bool)
+	m.imports = make(map[string]string)
+	scope := m.check.pkg.Scope()
+
+	for _, md := range m.decls {
+		dst.Inspect(md.decl, func(n dst.Node) bool {
+			switch n := n.(type) {
+			case *dst.SelectorExpr:
+				if id, ok := n.X.(*dst.Ident); ok {
+					if pn, ok := m.check.object(id).(*types.PkgName); ok {
+						if pn.Imported().Path() == m.target.path {
+							m.tgtQuals[n] = true
+						} else {
+							m.imports[pn.Imported().Path()] = pn.Name()
+						}
+					}
+				}
+
+				if sel := m.check.selection(n); sel != nil && err == nil {
+					obj := sel.Obj()
+					if obj.Pkg() == m.check.pkg && !obj.Exported() && !m.moved[obj] && !m.movedField(sel) {
+						err = fmt.Errorf("%w: %s", ErrUnexported, obj.Name())
+					}
+				}
+			case *dst.Ident:
+				obj := m.check.object(n)
+				if obj != nil && obj.Parent() == scope && !m.moved[obj] {
+					m.srcRefs[n] = true
+					if !obj.Exported() && err == nil {
+						err = fmt.Errorf("%w: %s is referenced by %s", ErrUnexported, obj.Name(), m.name)
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	for _, filename := range sortedFiles(m.src) {
+		for _, decl := range m.src.dfiles[filename].Decls {
+			if m.isMoved(decl) {
+				continue
+			}
+
+			dst.Inspect(decl, func(n dst.Node) bool {
+				switch n := n.(type) {
+				case dst.Spec:
+					return !m.specs[n]
+				case *dst.SelectorExpr:
+					if sel := m.check.selection(n); sel != nil && err == nil {
+						if obj := sel.Obj(); !obj.Exported() && (m.moved[obj] || m.movedField(sel)) {
+							err = fmt.Errorf("%w: %s is referenced from package %s", ErrUnexported, obj.Name(), m.src.pkgname)
+						}
+					}
+				case *dst.Ident:
+					if obj := m.check.object(n); m.moved[obj] && obj.Parent() == scope {
+						m.tgtRefs[n] = true
+						if !ast.IsExported(m.names[obj.Name()]) && err == nil {
+							err = fmt.Errorf("%w: %s is referenced from package %s", ErrUnexported, obj.Name(), m.src.pkgname)
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	if err == nil {
+		err = m.checkCycles()
+	}
+	return err
+}
+
+// checkCycles makes sure that the move does not leave the source and
+// target packages (or the importers and the target) importing each other
+func (m *mover) checkCycles() error {
+	needSrc := len(m.srcRefs) > 0
+	needTgt := len(m.tgtRefs) > 0
+	if needSrc && needTgt {
+		return fmt.Errorf("%w: %s and %s would import each other", ErrImportCycle, m.src.path, m.target.path)
+	}
+
+	if needTgt && imports(m.target, m.src.path) {
+		return fmt.Errorf("%w: %s already imports %s", ErrImportCycle, m.target.path, m.src.path)
+	}
+
+	if needSrc && imports(m.src, m.target.path) {
+		return fmt.Errorf("%w: %s already imports %s", ErrImportCycle, m.src.path, m.target.path)
+	}
+
+	for _, importer := range m.importers {
+		if importer != m.target && imports(m.target, importer.path) {
+			return fmt.Errorf("%w: %s imports %s", ErrImportCycle, m.target.path, importer.path)
+		}
+	}
+	return nil
+}
+
+// isMoved determines if the declaration is being moved in its entirety
+func (m *mover) isMoved(decl dst.Decl) bool {
+	for _, md := range m.decls {
+		if md.decl == decl {
+			return true
+		}
+	}
+	return false
+}
+
+// movedField determines if the selection is a field of a type
+// that is being moved
+func (m *mover) movedField(sel *types.Selection) bool {
+	if sel.Kind() != types.FieldVal {
+		return false
+	}
+
+	recv := sel.Recv()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+
+	if named, ok := recv.(*types.Named); ok {
+		return m.moved[named.Obj()]
+	}
+	return false
+}
+
+// move performs the move after the declarations have been collected
+// and analyzed.  No errors can occur after this point so all of the
+// sessions are left consistent
+func (m *mover) move(importers map[*Tools]*checked) {
+	scope := m.check.pkg.Scope()
+	file := m.target.dfiles[m.targetFile]
+	srcName := ""
+	if len(m.srcRefs) > 0 {
+		srcName = addImport(file, m.src.pkgname, m.src.path)
+	}
+
+	// rewrite the moved declarations for their new home
+	for _, md := range m.decls {
+		if md.parent != nil {
+			spec := md.decl.(*dst.GenDecl).Specs[0]
+			md.decl.Decorations().Start = spec.Decorations().Start
+			spec.Decorations().Start = nil
+			spec.Decorations().Before = dst.None
+			spec.Decorations().After = dst.None
+		}
+
+		dstutil.Apply(md.decl, func(cursor *dstutil.Cursor) bool {
+			switch n := cursor.Node().(type) {
+			case *dst.SelectorExpr:
+				if m.tgtQuals[n] {
+					id := dst.NewIdent(n.Sel.Name)
+					id.Decs.NodeDecs = n.Decs.NodeDecs
+					cursor.Replace(id)
+					return false
+				}
+			case *dst.Ident:
+				if m.srcRefs[n] {
+					cursor.Replace(qualify(n, srcName, n.Name))
+				} else if obj := m.check.object(n); m.moved[obj] && obj.Parent() == scope {
+					n.Name = m.names[n.Name]
+				}
+			}
+			return true
+		}, nil)
+	}
+
+	// remove the moved declarations and point the remaining
+	// references at the target package
+	pkgNames := make(map[string]string)
+	for _, pkg := range m.check.pkg.Imports() {
+		pkgNames[pkg.Path()] = pkg.Name()
+	}
+
+	for _, filename := range sortedFiles(m.src) {
+		file := m.src.dfiles[filename]
+		changed := false
+		decls := file.Decls[:0]
+		for _, decl := range file.Decls {
+			if m.isMoved(decl) {
+				changed = true
+				continue
+			}
+			decls = append(decls, decl)
+		}
+		file.Decls = decls
+
+		for _, md := range m.decls {
+			if md.parent != nil && md.filename == filename {
+				m.removeSpec(md)
+			}
+		}
+
+		name := ""
+		file = dstutil.Apply(file, func(cursor *dstutil.Cursor) bool {
+			if id, ok := cursor.Node().(*dst.Ident); ok && m.tgtRefs[id] {
+				if name == "" {
+					name = addImport(file, m.target.pkgname, m.target.path)
+				}
+				cursor.Replace(qualify(id, name, m.names[id.Name]))
+			}
+			return true
+		}, nil).(*dst.File)
+		m.src.dfiles[filename] = file
+
+		if changed {
+			for path, pkgName := range pkgNames {
+				pruneImport(file, path, pkgName)
+			}
+		}
+	}
+
+	// rewrite the references in the importers, including the target
+	for session, check := range importers {
+		for _, filename := range sortedFiles(session) {
+			m.rewriteImporter(session, check, filename)
+		}
+	}
+
+	// finally, add the declarations to the target file
+	for path, name := range m.imports {
+		addImport(file, name, path)
+	}
+
+	for _, md := range m.decls {
+		md.decl.Decorations().Before = dst.EmptyLine
+		md.decl.Decorations().After = dst.EmptyLine
+		file.Decls = append(file.Decls, md.decl)
+	}
+}
+
+// removeSpec removes the spec of an extracted declaration from the
+// block it was originally declared in
+func (m *mover) removeSpec(md movedDecl) {
+	spec := md.decl.(*dst.GenDecl).Specs[0]
+	specs := md.parent.Specs[:0]
+	for _, s := range md.parent.Specs {
+		if s != spec {
+			specs = append(specs, s)
+		}
+	}
+	md.parent.Specs = specs
+}
+
+// rewriteImporter changes the qualified references to moved
+// declarations in the file to refer to the target package
+func (m *mover) rewriteImporter(session *Tools, check *checked, filename string) {
+	file := session.dfiles[filename]
+	name := ""
+	changed := false
+	file = dstutil.Apply(file, func(cursor *dstutil.Cursor) bool {
+		sel, ok := cursor.Node().(*dst.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		id, ok := sel.X.(*dst.Ident)
+		if !ok {
+			return true
+		}
+
+		pn, ok := check.object(id).(*types.PkgName)
+		if !ok || pn.Imported().Path() != m.src.path {
+			return true
+		}
+
+		newName, found := m.names[sel.Sel.Name]
+		if !found {
+			return true
+		}
+
+		changed = true
+		if session == m.target {
+			nid := dst.NewIdent(newName)
+			nid.Decs.NodeDecs = sel.Decs.NodeDecs
+			cursor.Replace(nid)
+		} else {
+			if name == "" {
+				name = addImport(file, m.target.pkgname, m.target.path)
+			}
+			cursor.Replace(qualify(sel.Sel, name, newName))
+		}
+		return false
+	}, nil).(*dst.File)
+
+	if changed {
+		pruneImport(file, m.src.path, m.src.pkgname)
+	}
+	session.dfiles[filename] = file
+}
+
+// declNames returns the identifiers declared by a declaration
+func declNames(decl dst.Decl) (names []*dst.Ident) {
+	switch d := decl.(type) {
+	case *dst.FuncDecl:
+		names = append(names, d.Name)
+	case *dst.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *dst.TypeSpec:
+				names = append(names, s.Name)
+			case *dst.ValueSpec:
+				names = append(names, s.Names...)
+			}
+		}
+	}
+	return names
+}
+
+// imports determines if any file in the session imports path
+func imports(session *Tools, path string) bool {
+	for _, file := range session.dfiles {
+		if findImport(file, path) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// qualify returns a selector expression referencing name in package
+// pkg, carrying over the decorations of the identifier it replaces
+func qualify(id *dst.Ident, pkg, name string) *dst.SelectorExpr {
+	sel := &dst.SelectorExpr{
+		X:   dst.NewIdent(pkg),
+		Sel: dst.NewIdent(name),
+	}
+	sel.Decs.NodeDecs = id.Decs.NodeDecs
+	return sel
+}
+
+// sortedFiles returns the names of the files in the session in
+// lexical order
+func sortedFiles(session *Tools) []string {
+	filenames := []string{}
+	for filename := range session.dfiles {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// usesIota determines if any of the values in the spec reference iota
+func usesIota(spec *dst.ValueSpec) (found bool) {
+	for _, value := range spec.Values {
+		dst.Inspect(value, func(n dst.Node) bool {
+			if id, ok := n.(*dst.Ident); ok && id.Name == "iota" {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// Move relocates the declaration called name, with its methods and
+// constructors if it is a type, to targetFile of the target package and
+// rewrites the references in this package, the target and the importers.
+// Both packages need import paths and Undo reverts every session
+func (f *Tools) Move(name, rename string, target *Tools, targetFile string, importers ...*Tools) error {
+	defer f.operation()()
+	if f.path == "" || target.path == "" {
+		return ErrNoImportPath
+	}
+
+	if _, found := target.dfiles[targetFile]; !found {
+		return fmt.Errorf("%q: %w", targetFile, fs.ErrNotExist)
+	}
+
+	m := &mover{
+		src:        f,
+		target:     target,
+		targetFile: targetFile,
+		importers:  importers,
+		name:       name,
+		rename:     rename,
+		check:      f.edit(),
+	}
+
+	err := m.collect()
+	if err == nil {
+		err = m.analyze()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	sessions := []*Tools{f, target}
+	checks := map[*Tools]*checked{target: target.edit()}
+	for _, importer := range importers {
+		if importer != f && importer != target {
+			sessions = append(sessions, importer)
+			checks[importer] = importer.edit()
+		}
+	}
+	defer f.join(sessions...)()
+
+	starts := make([]map[string][]byte, len(sessions))
+	for i, session := range sessions {
+		starts[i] = session.snapshot()
+	}
+
+	m.move(checks)
+
+	for i, session := range sessions {
+		if rerr := session.recordAll(starts[i]); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
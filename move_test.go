@@ -0,0 +1,252 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMove(t *testing.T) {
+	src := newSession(t, "example.com/internal/widget", map[string]string{
+		"widget.go": `package widget
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Widget is a thing
+type Widget struct {
+	Name string
+}
+
+// NewWidget creates a Widget
+func NewWidget(name string) *Widget {
+	return &Widget{Name: strings.ToUpper(name)}
+}
+
+func (w *Widget) String() string {
+	return fmt.Sprintf("widget %s", w.Name)
+}
+
+func Describe(w *Widget) string {
+	return w.String()
+}
+`,
+	})
+
+	target := newSession(t, "example.com/shared", map[string]string{
+		"shared.go": `package shared
+
+const Version = 1
+`,
+	})
+
+	importer := newSession(t, "example.com/cmd", map[string]string{
+		"main.go": `package main
+
+import "example.com/internal/widget"
+
+func main() {
+	w := widget.NewWidget("foo")
+	println(widget.Describe(w))
+}
+`,
+	})
+
+	err := src.Move("Widget", "", target, "shared.go", importer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	wantSource(t, src, "widget.go", `package widget
+
+import "example.com/shared"
+
+func Describe(w *shared.Widget) string {
+	return w.String()
+}
+`)
+
+	wantSource(t, target, "shared.go", `package shared
+
+import (
+	"fmt"
+	"strings"
+)
+
+const Version = 1
+
+// Widget is a thing
+type Widget struct {
+	Name string
+}
+
+// NewWidget creates a Widget
+func NewWidget(name string) *Widget {
+	return &Widget{Name: strings.ToUpper(name)}
+}
+
+func (w *Widget) String() string {
+	return fmt.Sprintf("widget %s", w.Name)
+}
+`)
+
+	wantSource(t, importer, "main.go", `package main
+
+import (
+	"example.com/internal/widget"
+	"example.com/shared"
+)
+
+func main() {
+	w := shared.NewWidget("foo")
+	println(widget.Describe(w))
+}
+`)
+
+	if len(src.Changes()) != 1 || len(target.Changes()) != 1 || len(importer.Changes()) != 1 {
+		t.Errorf("Wanted one change in each package")
+	}
+
+	// the move is undone as a whole
+	if err := src.Undo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, session := range []*Tools{src, target, importer} {
+		if len(session.Changes()) != 0 {
+			t.Errorf("Wanted no changes after undo got %v", session.Changes())
+		}
+	}
+	wantSource(t, target, "shared.go", "package shared\n\nconst Version = 1\n")
+
+	if err := src.Redo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(target.Changes()) != 1 || len(importer.Changes()) != 1 {
+		t.Errorf("Wanted the move to be redone in each package")
+	}
+}
+
+func TestMoveRollback(t *testing.T) {
+	src := newSession(t, "example.com/widget", map[string]string{
+		"widget.go": `package widget
+
+type Widget struct{}
+
+func NewWidget() *Widget {
+	return &Widget{}
+}
+`,
+	})
+
+	target := newSession(t, "example.com/shared", map[string]string{
+		"shared.go": "package shared\n\nconst Version = 1\n",
+	})
+
+	importer := newSession(t, "example.com/cmd", map[string]string{
+		"main.go": `package main
+
+import "example.com/widget"
+
+func main() {
+	println(widget.NewWidget())
+}
+`,
+	})
+
+	src.Begin()
+	if err := src.Move("Widget", "", target, "shared.go", importer); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := src.Rollback(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, session := range []*Tools{src, target, importer} {
+		if len(session.Changes()) != 0 {
+			t.Errorf("Wanted no changes after rollback got %v", session.Changes())
+		}
+	}
+
+	wantSource(t, target, "shared.go", "package shared\n\nconst Version = 1\n")
+	wantSource(t, importer, "main.go", `package main
+
+import "example.com/widget"
+
+func main() {
+	println(widget.NewWidget())
+}
+`)
+
+	if err := src.Undo(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("Wanted ErrNoHistory got %v", err)
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		move    string
+		rename  string
+		wantErr error
+	}{
+		{
+			name: "not found",
+			input: `package foo
+			func bar() {}`,
+			move:    "baz",
+			wantErr: ErrDeclNotFound,
+		},
+		{
+			name: "unexported reference",
+			input: `package foo
+			type widget int
+			func use(w widget) {}`,
+			move:    "widget",
+			wantErr: ErrUnexported,
+		},
+		{
+			name: "import cycle",
+			input: `package foo
+			type Widget int
+			func (w Widget) Foo() int { return Helper() }
+			func Helper() int { return 1 }
+			func use(w Widget) {}`,
+			move:    "Widget",
+			wantErr: ErrImportCycle,
+		},
+		{
+			name: "iota block",
+			input: `package foo
+			const (
+				A = iota
+				B
+			)`,
+			move:    "B",
+			wantErr: ErrUnsupported,
+		},
+		{
+			name: "renamed",
+			input: `package foo
+			type widget int
+			func use(w widget) {}`,
+			move:   "widget",
+			rename: "Widget",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := newSession(t, "example.com/foo", map[string]string{"foo.go": test.input})
+			target := newSession(t, "example.com/bar", map[string]string{"bar.go": "package bar\n"})
+			err := src.Move(test.move, test.rename, target, "bar.go")
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Wanted error %v got %v", test.wantErr, err)
+			}
+		})
+	}
+}
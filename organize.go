@@ -19,8 +19,9 @@ func Organize(filename string, input []byte) (output []byte, err error) {
 }
 
 type organizer struct {
-	file  *dst.File
-	types map[string]sortableSource
+	file    *dst.File
+	imports ImportRules
+	types   map[string]sortableSource
 }
 
 func (o *organizer) analyzeTypes() (names []string) {
@@ -76,11 +77,11 @@ func (o *organizer) analyzeValue(cursor *dstutil.Cursor) {
 	decl := cursor.Node().(*dst.GenDecl)
 	vs := decl.Specs[0].(*dst.ValueSpec)
 	typName := ""
-	if decl.Lparen && len(vs.Names) == 1 {
-		if vs.Type == nil {
-			typName = typStr(vs.Values[0])
-		} else {
+	if decl.Lparen {
+		if vs.Type != nil {
 			typName = typStr(vs.Type)
+		} else if len(vs.Values) > 0 {
+			typName = typStr(vs.Values[0])
 		}
 	}
 
@@ -90,7 +91,192 @@ func (o *organizer) analyzeValue(cursor *dstutil.Cursor) {
 	}
 }
 
+// group returns the name of the type that the declaration would be
+// grouped with by organize, or an empty string if the declaration
+// would not be part of any type's group
+func (o *organizer) group(decl dst.Decl) string {
+	typName := ""
+	switch d := decl.(type) {
+	case *dst.FuncDecl:
+		if d.Recv != nil {
+			typName = typStr(d.Recv.List[0].Type)
+		} else if d.Type.Results != nil {
+			for _, result := range d.Type.Results.List {
+				if _, found := o.types[typStr(result.Type)]; found {
+					typName = typStr(result.Type)
+					break
+				}
+			}
+		}
+	case *dst.GenDecl:
+		switch spec := d.Specs[0].(type) {
+		case *dst.TypeSpec:
+			if !d.Lparen {
+				typName = spec.Name.Name
+			}
+		case *dst.ValueSpec:
+			if d.Lparen {
+				if spec.Type == nil && len(spec.Values) > 0 {
+					typName = typStr(spec.Values[0])
+				} else {
+					typName = typStr(spec.Type)
+				}
+			}
+		}
+	}
+
+	if _, found := o.types[typName]; found {
+		return typName
+	}
+	return ""
+}
+
+// insert adds the declaration to the file at the position that
+// organize would place it relative to the declarations in the same
+// group. The rest of the file is left as it is
+func (o *organizer) insert(decl dst.Decl) {
+	o.types = make(map[string]sortableSource)
+	for _, d := range o.file.Decls {
+		if gd, ok := d.(*dst.GenDecl); ok && gd.Tok == token.TYPE && !gd.Lparen {
+			o.types[gd.Specs[0].(*dst.TypeSpec).Name.Name] = nil
+		}
+	}
+
+	// a new type starts its own group
+	if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.TYPE && !gd.Lparen {
+		o.types[gd.Specs[0].(*dst.TypeSpec).Name.Name] = nil
+	}
+
+	group := o.group(decl)
+	index := len(o.file.Decls)
+	last := -1
+	for i, d := range o.file.Decls {
+		if o.group(d) != group {
+			continue
+		}
+
+		if (sortableSource{decl, d}).Less(0, 1) {
+			index = i
+			break
+		}
+		last = i
+	}
+
+	if index == len(o.file.Decls) && last >= 0 {
+		index = last + 1
+	} else if index == len(o.file.Decls) && group != "" {
+		// groups are ordered by the name of their type
+		for i, d := range o.file.Decls {
+			if g := o.group(d); g != "" && group < g {
+				index = i
+				break
+			}
+		}
+	}
+
+	decl.Decorations().Before = dst.EmptyLine
+	decl.Decorations().After = dst.EmptyLine
+	o.file.Decls = append(o.file.Decls[:index], append([]dst.Decl{decl}, o.file.Decls[index:]...)...)
+}
+
+// isCgoImport determines if the declaration imports "C"
+func isCgoImport(decl *dst.GenDecl) bool {
+	for _, spec := range decl.Specs {
+		if importPath(spec.(*dst.ImportSpec)) == "C" {
+			return true
+		}
+	}
+	return false
+}
+
+// organizeImports merges the import declarations of the file into the
+// first one and arranges the imports into sections according to the
+// import rules.  Duplicate imports are dropped.  The declaration that
+// imports "C" is left alone since its doc comment is the cgo preamble
+func (o *organizer) organizeImports() {
+	var merged *dst.GenDecl
+	specs := []*dst.ImportSpec{}
+	imports := []*dst.ImportSpec{}
+	seen := make(map[string]bool)
+	decls := o.file.Decls[:0]
+	for _, decl := range o.file.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+		if !ok || gd.Tok != token.IMPORT || isCgoImport(gd) {
+			if ok && gd.Tok == token.IMPORT {
+				for _, spec := range gd.Specs {
+					imports = append(imports, spec.(*dst.ImportSpec))
+				}
+			}
+			decls = append(decls, decl)
+			continue
+		}
+
+		if merged == nil {
+			merged = gd
+			decls = append(decls, decl)
+		} else if len(gd.Specs) > 0 {
+			// the doc comment of a merged declaration stays with its
+			// first import
+			decs := gd.Specs[0].Decorations()
+			decs.Start = append(append(dst.Decorations{}, gd.Decs.Start...), decs.Start...)
+		}
+
+		for _, spec := range gd.Specs {
+			is := spec.(*dst.ImportSpec)
+			key := importPath(is)
+			if is.Name != nil {
+				key = is.Name.Name + " " + key
+			}
+
+			if !seen[key] {
+				seen[key] = true
+				specs = append(specs, is)
+			}
+		}
+	}
+
+	if merged == nil || len(specs) == 0 {
+		return
+	}
+	o.file.Decls = decls
+
+	sort.SliceStable(specs, func(i, j int) bool {
+		si, ri := o.imports.section(specs[i])
+		sj, rj := o.imports.section(specs[j])
+		if si != sj {
+			return si < sj
+		} else if ri != rj {
+			return ri < rj
+		}
+		return importPath(specs[i]) < importPath(specs[j])
+	})
+
+	merged.Specs = merged.Specs[:0]
+	last := -1
+	for _, spec := range specs {
+		spec.Decs.Before = dst.NewLine
+		spec.Decs.After = dst.NewLine
+		if section, _ := o.imports.section(spec); section != last {
+			if last >= 0 {
+				spec.Decs.Before = dst.EmptyLine
+			}
+			last = section
+		}
+		merged.Specs = append(merged.Specs, spec)
+	}
+
+	if len(specs) == 1 && !merged.Lparen && len(specs[0].Decs.Start) == 0 {
+		specs[0].Decs.Before = dst.None
+		specs[0].Decs.After = dst.None
+	} else {
+		merged.Lparen = true
+		merged.Rparen = true
+	}
+	o.file.Imports = append(imports, specs...)
+}
+
 func (o *organizer) organize() *dst.File {
+	o.organizeImports()
 	names := o.analyzeTypes()
 
 	walk := func(cursor *dstutil.Cursor) bool {
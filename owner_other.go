@@ -0,0 +1,11 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package tools
+
+import "os"
+
+// chown is a no-op on platforms without unix file ownership
+func chown(filename string, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package tools
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown gives the file the owner and group of the file described by fi.
+// Permission errors are ignored since only privileged users can change
+// the owner of a file
+func chown(filename string, fi os.FileInfo) error {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(filename, int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+			return err
+		}
+	}
+	return nil
+}
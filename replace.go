@@ -3,6 +3,7 @@ package tools
 import (
 	"bytes"
 	"fmt"
+	"path/filepath"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
@@ -12,19 +13,29 @@ import (
 type Replacer struct {
 	filename string
 	file     *dst.File
+	index    *importIndex
 	Err      error
+
+	// LocalPrefix is the import path prefix of the packages that
+	// belong to the local organization
+	LocalPrefix string
 }
 
 func Replace(filename string, src []byte) (r *Replacer) {
 	r = &Replacer{
 		filename: filename,
+		index:    &importIndex{},
 	}
 
 	r.file, r.Err = decorator.Parse(src)
 	return r
 }
 
+// Content returns the source of the file with its imports fixed
+// to match the packages that the replaced functions use
 func (r *Replacer) Content() []byte {
+	fixImports(r.file, filepath.Dir(r.filename), declaredNames(r.file), r.LocalPrefix, r.index)
+
 	writer := &bytes.Buffer{}
 	decorator.Fprint(writer, r.file)
 	return writer.Bytes()
@@ -40,20 +51,47 @@ func (r *Replacer) Func(name, receiver string, content string) *Replacer {
 		}
 		src := tmpfile.Decls[0]
 		src.Decorations().After = dst.EmptyLine
+		r.file, _ = replaceFunc(r.file, name, receiver, src)
+	}
+	return r
+}
 
-		walk := func(cursor *dstutil.Cursor) bool {
-			if fn, ok := cursor.Node().(*dst.FuncDecl); ok {
-				if fn.Name.String() == name {
-					if (fn.Recv == nil && receiver == "") || (fn.Recv != nil && typStr(fn.Recv.List[0].Type) == receiver) {
-						cursor.Replace(src)
-						return false
-					}
+// replaceFunc replaces the declaration of the function called name, or
+// the method of the receiver type, with decl.  The returned bool
+// reports whether the function was found
+func replaceFunc(file *dst.File, name, receiver string, decl dst.Decl) (*dst.File, bool) {
+	found := false
+	walk := func(cursor *dstutil.Cursor) bool {
+		if fn, ok := cursor.Node().(*dst.FuncDecl); ok {
+			if fn.Name.String() == name {
+				if (fn.Recv == nil && receiver == "") || (fn.Recv != nil && typStr(fn.Recv.List[0].Type) == receiver) {
+					cursor.Replace(decl)
+					found = true
+					return false
 				}
 			}
-			return true
 		}
+		return true
+	}
 
-		r.file = dstutil.Apply(r.file, nil, walk).(*dst.File)
+	return dstutil.Apply(file, nil, walk).(*dst.File), found
+}
+
+// upsert replaces the existing declaration of the function in the file
+// or, if there isn't one, inserts the function where Organize would
+// place it
+func (f *Tools) upsert(filename string, fn *dst.FuncDecl) {
+	receiver := ""
+	if fn.Recv != nil {
+		receiver = typStr(fn.Recv.List[0].Type)
+	}
+
+	file, found := replaceFunc(f.dfiles[filename], fn.Name.Name, receiver, fn)
+	if found {
+		fn.Decs.After = dst.EmptyLine
+		f.dfiles[filename] = file
+	} else {
+		o := &organizer{file: file}
+		o.insert(fn)
 	}
-	return r
 }
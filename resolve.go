@@ -0,0 +1,385 @@
+package tools
+
+import (
+	"bufio"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// packageIndex maps package names to the import paths of the packages
+// that declare them
+type packageIndex map[string][]string
+
+// importIndex finds the packages that a session's files import.  The
+// standard library and modules are only indexed the first time a file
+// refers to a package that it does not import
+type importIndex struct {
+	mu      sync.Mutex
+	stdlib  packageIndex
+	modules map[string]packageIndex    // go.mod filename -> index
+	dirs    map[string]string          // import path -> directory
+	exports map[string]map[string]bool // directory -> exported names
+}
+
+// init allocates the maps of the index
+func (ix *importIndex) init() {
+	if ix.dirs == nil {
+		ix.modules = make(map[string]packageIndex)
+		ix.dirs = make(map[string]string)
+		ix.exports = make(map[string]map[string]bool)
+	}
+}
+
+// packageName returns the name declared by the package in dir.  Commands,
+// directories without Go files and test only packages have no name
+func packageName(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil && file.Name.Name != "main" && file.Name.Name != "documentation" {
+			return file.Name.Name
+		}
+	}
+	return ""
+}
+
+// indexTree adds the packages found under root to the index.  The
+// import path of root is given by prefix.  Nested modules, testdata and
+// vendor directories are skipped as are internal packages unless
+// internal is true
+func (ix *importIndex) indexTree(index packageIndex, root, prefix string, internal bool) {
+	filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+
+		name := entry.Name()
+		if path != root {
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor" || (name == "internal" && !internal) {
+				return filepath.SkipDir
+			}
+
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+
+		if pkg := packageName(path); pkg != "" {
+			rel, _ := filepath.Rel(root, path)
+			importPath := prefix
+			if rel != "." {
+				importPath = strings.TrimPrefix(prefix+"/"+filepath.ToSlash(rel), "/")
+			}
+			index[pkg] = append(index[pkg], importPath)
+			ix.dirs[importPath] = path
+		}
+		return nil
+	})
+}
+
+// standardLibrary returns the index of the packages in GOROOT
+func (ix *importIndex) standardLibrary() packageIndex {
+	if ix.stdlib == nil {
+		ix.stdlib = make(packageIndex)
+		root := filepath.Join(build.Default.GOROOT, "src")
+		ix.indexTree(ix.stdlib, root, "", false)
+		for name, paths := range ix.stdlib {
+			filtered := paths[:0]
+			for _, path := range paths {
+				if !strings.HasPrefix(path, "cmd/") {
+					filtered = append(filtered, path)
+				}
+			}
+			ix.stdlib[name] = filtered
+		}
+	}
+	return ix.stdlib
+}
+
+// findModule returns the go.mod file that governs dir, or an empty
+// string if dir is not within a module
+func findModule(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+
+	for {
+		gomod := filepath.Join(dir, "go.mod")
+		if _, err := os.Stat(gomod); err == nil {
+			return gomod
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readModule returns the module path and the required modules, along
+// with their versions, of the go.mod file
+func readModule(gomod string) (path string, requires map[string]string) {
+	requires = make(map[string]string)
+	file, err := os.Open(gomod)
+	if err != nil {
+		return "", requires
+	}
+	defer file.Close()
+
+	block := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+
+		switch {
+		case len(fields) == 0:
+		case block && fields[0] == ")":
+			block = false
+		case block && len(fields) >= 2:
+			requires[fields[0]] = fields[1]
+		case fields[0] == "module" && len(fields) == 2:
+			path = strings.Trim(fields[1], `"`)
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			block = true
+		case fields[0] == "require" && len(fields) >= 3:
+			requires[fields[1]] = fields[2]
+		}
+	}
+	return path, requires
+}
+
+// escapeModulePath escapes the upper case letters of a module path as
+// the module cache does
+func escapeModulePath(path string) string {
+	b := &strings.Builder{}
+	for _, r := range path {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// moduleCache returns the directory of the module download cache
+func moduleCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.SplitList(build.Default.GOPATH)[0], "pkg", "mod")
+}
+
+// moduleIndex returns the index of the packages of the module that
+// contains dir and of the modules it requires that are in the module
+// cache.  Nothing is downloaded
+func (ix *importIndex) moduleIndex(dir string) packageIndex {
+	gomod := findModule(dir)
+	if gomod == "" {
+		return nil
+	}
+
+	if index, found := ix.modules[gomod]; found {
+		return index
+	}
+
+	index := make(packageIndex)
+	path, requires := readModule(gomod)
+	ix.indexTree(index, filepath.Dir(gomod), path, true)
+	for module, version := range requires {
+		moduleDir := filepath.Join(moduleCache(), escapeModulePath(module)+"@"+version)
+		if _, err := os.Stat(moduleDir); err == nil {
+			ix.indexTree(index, moduleDir, module, false)
+		}
+	}
+
+	ix.modules[gomod] = index
+	return index
+}
+
+// packageDir returns the directory of the package with the import path
+// in the standard library, the module containing dir or the modules it
+// requires.  The directory is found without indexing the packages
+func (ix *importIndex) packageDir(dir, path string) string {
+	if pkgDir, found := ix.dirs[path]; found {
+		return pkgDir
+	}
+
+	candidates := []string{filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(path))}
+	if gomod := findModule(dir); gomod != "" {
+		modPath, requires := readModule(gomod)
+		requires[modPath] = ""
+		for module, version := range requires {
+			root := filepath.Dir(gomod)
+			if module != modPath {
+				root = filepath.Join(moduleCache(), escapeModulePath(module)+"@"+version)
+			}
+
+			if path == module {
+				candidates = append(candidates, root)
+			} else if strings.HasPrefix(path, module+"/") {
+				candidates = append(candidates, filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(path, module+"/"))))
+			}
+		}
+	}
+
+	pkgDir := ""
+	for _, candidate := range candidates {
+		if fi, err := os.Stat(candidate); err == nil && fi.IsDir() {
+			pkgDir = candidate
+			break
+		}
+	}
+
+	ix.dirs[path] = pkgDir
+	return pkgDir
+}
+
+// addDeclaredNames adds the package level names declared by the file
+// to names.  If exported is true only exported names are added
+func addDeclaredNames(names map[string]bool, file *ast.File, exported bool) {
+	add := func(id *ast.Ident) {
+		if !exported || id.IsExported() {
+			names[id.Name] = true
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				add(d.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add(s.Name)
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						add(id)
+					}
+				}
+			}
+		}
+	}
+}
+
+// exportsOf returns the exported package level names declared by the
+// package in dir
+func (ix *importIndex) exportsOf(dir string) map[string]bool {
+	if names, found := ix.exports[dir]; found {
+		return names
+	}
+
+	names := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+
+		addDeclaredNames(names, file, true)
+	}
+
+	ix.exports[dir] = names
+	return names
+}
+
+// siblingNames returns the package level names declared by the files
+// of package pkg in dir
+func siblingNames(dir, pkg string) map[string]bool {
+	names := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, entry.Name()), nil, parser.SkipObjectResolution)
+		if err != nil || file.Name.Name != pkg {
+			continue
+		}
+
+		addDeclaredNames(names, file, false)
+	}
+	return names
+}
+
+// resolveImport returns the import path of the package called name that
+// exports every one of the symbols.  The standard library is searched
+// before the module containing dir and its requirements.  When several
+// packages match, the one with the shortest import path is used.  An
+// empty string is returned if no package matches
+func (ix *importIndex) resolveImport(dir, name string, symbols map[string]bool) string {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.init()
+	for _, index := range []packageIndex{ix.standardLibrary(), ix.moduleIndex(dir)} {
+		candidates := []string{}
+		for _, path := range index[name] {
+			names := ix.exportsOf(ix.dirs[path])
+			found := true
+			for symbol := range symbols {
+				found = found && names[symbol]
+			}
+
+			if found {
+				candidates = append(candidates, path)
+			}
+		}
+
+		if len(candidates) > 0 {
+			sort.Slice(candidates, func(i, j int) bool {
+				ni, nj := strings.Count(candidates[i], "/"), strings.Count(candidates[j], "/")
+				if ni != nj {
+					return ni < nj
+				}
+				return candidates[i] < candidates[j]
+			})
+			return candidates[0]
+		}
+	}
+	return ""
+}
+
+// resolvePackageName returns the name declared by the package with the
+// import path, if it can be found in the standard library or the module
+// containing dir
+func (ix *importIndex) resolvePackageName(dir, path string) string {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.init()
+	if pkgDir := ix.packageDir(dir, path); pkgDir != "" {
+		return packageName(pkgDir)
+	}
+	return ""
+}
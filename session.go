@@ -0,0 +1,258 @@
+package tools
+
+// fileState is the content of a file along with its entry in the change
+// set, if it had one
+type fileState struct {
+	content []byte
+	change  Change
+	changed bool
+}
+
+// states holds the state of each file of a session
+type states map[string]fileState
+
+// step holds the state, from before an operation, of each file that the
+// operation changed in each session so that the operation can be undone
+type step map[*Tools]states
+
+// savepoint is the state of the session when a transaction began, along
+// with the state of the other sessions that the transaction changed from
+// before it first joined them
+type savepoint struct {
+	files   map[string][]byte
+	changed map[string]Change
+	history int
+	joined  map[*Tools]savepoint
+}
+
+// operation groups the changes recorded until the returned function is
+// called into a single undo step.  Nested operations are part of the
+// outermost operation, ie:
+//
+//	defer f.operation()()
+func (f *Tools) operation() (done func()) {
+	f.depth++
+	if f.depth == 1 {
+		f.current = make(step)
+	}
+
+	return func() {
+		f.depth--
+		if f.depth == 0 {
+			if len(f.current) > 0 {
+				f.history = append(f.history, f.current)
+				f.future = nil
+			}
+			f.current = nil
+		}
+	}
+}
+
+// join records the changes made to the other sessions, until the
+// returned function is called, in the current operation of f so that
+// undoing the operation reverts every session
+func (f *Tools) join(sessions ...*Tools) (done func()) {
+	type saved struct {
+		current step
+		depth   int
+	}
+
+	previous := make(map[*Tools]saved)
+	for _, session := range sessions {
+		if _, found := previous[session]; session != f && !found {
+			previous[session] = saved{session.current, session.depth}
+			session.current, session.depth = f.current, session.depth+1
+
+			// a rollback of the open transactions restores the session too
+			for _, sp := range f.savepoints {
+				if _, found := sp.joined[session]; !found {
+					sp.joined[session] = session.savepoint()
+				}
+			}
+		}
+	}
+
+	return func() {
+		for session, s := range previous {
+			session.current, session.depth = s.current, s.depth
+		}
+	}
+}
+
+// save adds the state of the file, from before its content changed from
+// start, to the current operation's undo step
+func (f *Tools) save(filename string, start []byte) {
+	if f.current == nil {
+		// changes made outside of an operation are a step of their own
+		defer f.operation()()
+	}
+
+	files := f.current[f]
+	if files == nil {
+		files = make(states)
+		f.current[f] = files
+	}
+
+	if _, found := files[filename]; !found {
+		change, changed := f.changed[filename]
+		files[filename] = fileState{content: start, change: change, changed: changed}
+	}
+}
+
+// state returns the current state of the file
+func (f *Tools) state(filename string) fileState {
+	change, changed := f.changed[filename]
+	return fileState{content: f.print(filename), change: change, changed: changed}
+}
+
+// restore sets the content and change set entry of the file
+func (f *Tools) restore(filename string, state fileState) error {
+	err := f.parse(filename, state.content)
+	if state.changed {
+		f.changed[filename] = state.change
+	} else {
+		delete(f.changed, filename)
+	}
+	return err
+}
+
+// apply restores the files of the step, in every session it changed,
+// and returns the step that reverses it
+func (f *Tools) apply(s step) (reverse step, err error) {
+	reverse = make(step)
+	for session, files := range s {
+		reverse[session] = make(states)
+		for filename, state := range files {
+			reverse[session][filename] = session.state(filename)
+			if rerr := session.restore(filename, state); err == nil {
+				err = rerr
+			}
+		}
+	}
+	return reverse, err
+}
+
+// Undo reverses the last operation, such as Organize or Inline, that
+// changed the files.  ErrNoHistory is returned if there is nothing to
+// undo
+func (f *Tools) Undo() error {
+	if len(f.history) == 0 {
+		return ErrNoHistory
+	}
+
+	last := f.history[len(f.history)-1]
+	f.history = f.history[:len(f.history)-1]
+	reverse, err := f.apply(last)
+	f.future = append(f.future, reverse)
+	return err
+}
+
+// Redo repeats the last operation reversed by Undo.  Performing any other
+// operation clears the operations that can be redone.  ErrNoHistory is
+// returned if there is nothing to redo
+func (f *Tools) Redo() error {
+	if len(f.future) == 0 {
+		return ErrNoHistory
+	}
+
+	next := f.future[len(f.future)-1]
+	f.future = f.future[:len(f.future)-1]
+	reverse, err := f.apply(next)
+	f.history = append(f.history, reverse)
+	return err
+}
+
+// Begin starts a transaction.  The changes made to the session until the
+// matching Commit or Rollback can be kept or discarded together.
+// Transactions may be nested
+func (f *Tools) Begin() {
+	f.savepoints = append(f.savepoints, f.savepoint())
+}
+
+// savepoint returns the current state of the session
+func (f *Tools) savepoint() savepoint {
+	sp := savepoint{
+		files:   f.snapshot(),
+		changed: make(map[string]Change),
+		history: len(f.history),
+		joined:  make(map[*Tools]savepoint),
+	}
+
+	for filename, change := range f.changed {
+		sp.changed[filename] = change
+	}
+	return sp
+}
+
+// Commit ends the current transaction and keeps its changes.  The
+// operations performed during the transaction are undone together.
+// ErrNoTransaction is returned if no transaction has begun
+func (f *Tools) Commit() error {
+	if len(f.savepoints) == 0 {
+		return ErrNoTransaction
+	}
+
+	sp := f.savepoints[len(f.savepoints)-1]
+	f.savepoints = f.savepoints[:len(f.savepoints)-1]
+	if len(f.history) > sp.history+1 {
+		merged := make(step)
+		for _, s := range f.history[sp.history:] {
+			for session, files := range s {
+				if merged[session] == nil {
+					merged[session] = make(states)
+				}
+
+				for filename, state := range files {
+					if _, found := merged[session][filename]; !found {
+						merged[session][filename] = state
+					}
+				}
+			}
+		}
+		f.history = append(f.history[:sp.history], merged)
+	}
+	return nil
+}
+
+// Rollback ends the current transaction and returns the session, and any
+// session changed along with it by Move, to the state it was in when the
+// transaction began.  Files added during the transaction are removed from
+// the session.  ErrNoTransaction is
+// returned if no transaction has begun
+func (f *Tools) Rollback() (err error) {
+	if len(f.savepoints) == 0 {
+		return ErrNoTransaction
+	}
+
+	sp := f.savepoints[len(f.savepoints)-1]
+	f.savepoints = f.savepoints[:len(f.savepoints)-1]
+	err = f.rollback(sp)
+	for session, joined := range sp.joined {
+		if rerr := session.rollback(joined); err == nil {
+			err = rerr
+		}
+	}
+
+	f.history = f.history[:sp.history]
+	f.future = nil
+	return err
+}
+
+// rollback returns the files of the session to their state at the
+// savepoint
+func (f *Tools) rollback(sp savepoint) (err error) {
+	for filename := range f.dfiles {
+		if _, found := sp.files[filename]; !found {
+			delete(f.dfiles, filename)
+		}
+	}
+
+	for filename, content := range sp.files {
+		if perr := f.parse(filename, content); err == nil {
+			err = perr
+		}
+	}
+
+	f.changed = sp.changed
+	return err
+}
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+)
+
+const sessionInput = `package foo
+
+func b() {}
+
+func a() {}
+
+type point struct{ X, Y int }
+
+var origin = point{0, 0}
+`
+
+const sessionOrganized = `package foo
+
+var origin = point{0, 0}
+
+func a() {}
+
+func b() {}
+
+type point struct{ X, Y int }
+`
+
+const sessionKeyed = `package foo
+
+var origin = point{X: 0, Y: 0}
+
+func a() {}
+
+func b() {}
+
+type point struct{ X, Y int }
+`
+
+func TestUndoRedo(t *testing.T) {
+	tools := newSession(t, "", map[string]string{"foo.go": sessionInput})
+	if err := tools.OrganizeAll(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := tools.KeyLiterals(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionKeyed)
+
+	if err := tools.Undo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionOrganized)
+
+	if err := tools.Undo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionInput)
+
+	if len(tools.ChangedFiles()) != 0 {
+		t.Errorf("Wanted no changed files got %v", tools.ChangedFiles())
+	}
+
+	if err := tools.Undo(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("Wanted error %v got %v", ErrNoHistory, err)
+	}
+
+	if err := tools.Redo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionOrganized)
+
+	if err := tools.Redo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionKeyed)
+
+	if err := tools.Redo(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("Wanted error %v got %v", ErrNoHistory, err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	tools := newSession(t, "", map[string]string{"foo.go": sessionInput})
+	tools.Begin()
+	tools.OrganizeAll()
+	tools.KeyLiterals()
+	if err := tools.Add("bar.go", []byte("package foo\n")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := tools.Rollback(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionInput)
+
+	if _, found := tools.dfiles["bar.go"]; found {
+		t.Errorf("Expected bar.go to be removed by the rollback")
+	}
+
+	if len(tools.ChangedFiles()) != 0 {
+		t.Errorf("Wanted no changed files got %v", tools.ChangedFiles())
+	}
+
+	tools.Begin()
+	tools.OrganizeAll()
+	tools.KeyLiterals()
+	if err := tools.Commit(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionKeyed)
+
+	// the committed transaction is undone as a single step
+	if err := tools.Undo(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantSource(t, tools, "foo.go", sessionInput)
+
+	if err := tools.Commit(); !errors.Is(err, ErrNoTransaction) {
+		t.Errorf("Wanted error %v got %v", ErrNoTransaction, err)
+	}
+
+	if err := tools.Rollback(); !errors.Is(err, ErrNoTransaction) {
+		t.Errorf("Wanted error %v got %v", ErrNoTransaction, err)
+	}
+}
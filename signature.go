@@ -0,0 +1,712 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/dstutil"
+)
+
+// paramSpec describes a parameter of the new signature.  Existing
+// parameters are referred to by their index in the old signature,
+// new parameters have from set to -1 and supply a name, type and the
+// value that is passed at existing call sites
+type paramSpec struct {
+	from  int
+	name  string
+	typ   string
+	value string
+}
+
+// resultSpec describes a result that is added to the signature along
+// with the value returned by the existing return statements
+type resultSpec struct {
+	typ   string
+	value string
+}
+
+type signatureChanger struct {
+	check   *checked
+	params  []paramSpec
+	results []resultSpec
+	funcs   map[*types.Func]bool
+	edits   []func()
+}
+
+// related finds the set of functions that must change along with fn:
+// the interface methods that fn implements and every other method in
+// the package that implements those interface methods
+func (sc *signatureChanger) related(fn *types.Func) {
+	sc.funcs = map[*types.Func]bool{fn: true}
+	scope := sc.check.pkg.Scope()
+
+	named := []*types.Named{}
+	ifaces := []*types.Named{}
+	for _, name := range scope.Names() {
+		if tn, ok := scope.Lookup(name).(*types.TypeName); ok && !tn.IsAlias() {
+			if n, ok := tn.Type().(*types.Named); ok {
+				if _, ok := n.Underlying().(*types.Interface); ok {
+					ifaces = append(ifaces, n)
+				} else {
+					named = append(named, n)
+				}
+			}
+		}
+	}
+
+	method := func(t types.Type) *types.Func {
+		obj, _, _ := types.LookupFieldOrMethod(t, true, sc.check.pkg, fn.Name())
+		m, _ := obj.(*types.Func)
+		return m
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, iface := range ifaces {
+			im := method(iface)
+			if im == nil {
+				continue
+			}
+
+			linked := sc.funcs[im]
+			for _, n := range named {
+				if m := method(n); m != nil && sc.funcs[m] && types.Implements(types.NewPointer(n), iface.Underlying().(*types.Interface)) {
+					linked = true
+				}
+			}
+
+			if !linked {
+				continue
+			}
+
+			if !sc.funcs[im] {
+				sc.funcs[im] = true
+				changed = true
+			}
+
+			for _, n := range named {
+				if m := method(n); m != nil && !sc.funcs[m] && types.Implements(types.NewPointer(n), iface.Underlying().(*types.Interface)) {
+					sc.funcs[m] = true
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// validate makes sure the parameter specs are consistent with the
+// signature of the function
+func (sc *signatureChanger) validate(sig *types.Signature) error {
+	used := make(map[int]bool)
+	for i, p := range sc.params {
+		if p.from < 0 {
+			if p.typ == "" || p.value == "" {
+				return fmt.Errorf("%w: new parameter %q requires a type and value", ErrUnsupported, p.name)
+			}
+			continue
+		}
+
+		if p.from >= sig.Params().Len() || used[p.from] {
+			return fmt.Errorf("%w: invalid parameter index %d", ErrInvalidRange, p.from)
+		}
+		used[p.from] = true
+
+		if sig.Variadic() && p.from == sig.Params().Len()-1 && i != len(sc.params)-1 {
+			return fmt.Errorf("%w: the variadic parameter must remain last", ErrUnsupported)
+		}
+	}
+
+	if sig.Variadic() && !used[sig.Params().Len()-1] {
+		return fmt.Errorf("%w: the variadic parameter can not be removed", ErrUnsupported)
+	}
+	return nil
+}
+
+// funcType returns the declared function type of the function and the
+// body, if the function is not an interface method
+func (sc *signatureChanger) funcType(fn *types.Func) (*ast.FuncType, *ast.BlockStmt) {
+	for _, file := range sc.check.files {
+		for _, decl := range file.Decls {
+			if fd, ok := decl.(*ast.FuncDecl); ok && sc.check.info.Defs[fd.Name] == fn {
+				return fd.Type, fd.Body
+			}
+		}
+
+		var ft *ast.FuncType
+		ast.Inspect(file, func(n ast.Node) bool {
+			if field, ok := n.(*ast.Field); ok && len(field.Names) == 1 && sc.check.info.Defs[field.Names[0]] == fn {
+				ft, _ = field.Type.(*ast.FuncType)
+			}
+			return ft == nil
+		})
+
+		if ft != nil {
+			return ft, nil
+		}
+	}
+	return nil, nil
+}
+
+// declaration queues the changes to the function's declaration
+func (sc *signatureChanger) declaration(fn *types.Func) error {
+	ft, body := sc.funcType(fn)
+	if ft == nil {
+		return fmt.Errorf("%q: %w", fn.Name(), ErrDeclNotFound)
+	}
+	sig := fn.Type().(*types.Signature)
+
+	// flatten the old parameters so that they can be addressed by index
+	type param struct {
+		name *dst.Ident
+		typ  dst.Expr
+	}
+
+	old := []param{}
+	for _, field := range ft.Params.List {
+		dfield := sc.check.nodes.Dst.Nodes[field].(*dst.Field)
+		if len(dfield.Names) == 0 {
+			old = append(old, param{typ: dfield.Type})
+		}
+		for _, name := range dfield.Names {
+			old = append(old, param{name: name, typ: dfield.Type})
+		}
+	}
+
+	for i := range old {
+		removed := true
+		for _, p := range sc.params {
+			removed = removed && p.from != i
+		}
+
+		if removed && body != nil && sig.Params().At(i).Name() != "" && sig.Params().At(i).Name() != "_" {
+			v := sig.Params().At(i)
+			inUse := false
+			ast.Inspect(body, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && sc.check.info.Uses[id] == v {
+					inUse = true
+				}
+				return !inUse
+			})
+
+			if inUse {
+				return fmt.Errorf("%w: parameter %s of %s is still in use", ErrUnsupported, v.Name(), fn.Name())
+			}
+		}
+	}
+
+	for _, p := range sc.params {
+		if p.from < 0 && body != nil {
+			if err := sc.collides(fn, body, p.name); err != nil {
+				return err
+			}
+		}
+	}
+
+	named := len(old) > 0 && old[0].name != nil
+	fields := []*dst.Field{}
+	for _, p := range sc.params {
+		var name *dst.Ident
+		var typ dst.Expr
+		if p.from < 0 {
+			typ = parseExpr(p.typ)
+			if named || len(old) == 0 {
+				name = dst.NewIdent(p.name)
+			}
+		} else {
+			name, typ = old[p.from].name, old[p.from].typ
+		}
+
+		last := len(fields) - 1
+		if name != nil && last >= 0 && len(fields[last].Names) > 0 && exprString(fields[last].Type) == exprString(typ) {
+			fields[last].Names = append(fields[last].Names, name)
+			continue
+		}
+
+		field := &dst.Field{Type: dst.Clone(typ).(dst.Expr)}
+		if name != nil {
+			field.Names = []*dst.Ident{name}
+		}
+		fields = append(fields, field)
+	}
+
+	dft := sc.check.nodes.Dst.Nodes[ft].(*dst.FuncType)
+	sc.edits = append(sc.edits, func() {
+		dft.Params.List = fields
+		for _, r := range sc.results {
+			if dft.Results == nil {
+				dft.Results = &dst.FieldList{}
+			}
+			dft.Results.List = append(dft.Results.List, &dst.Field{Type: parseExpr(r.typ)})
+		}
+
+		if dft.Results != nil && len(dft.Results.List) > 1 {
+			dft.Results.Opening = true
+			dft.Results.Closing = true
+		}
+	})
+
+	if len(sc.results) > 0 && body != nil {
+		dbody := sc.check.nodes.Dst.Nodes[body].(*dst.BlockStmt)
+		if sig.Results().Len() > 0 && sig.Results().At(0).Name() != "" {
+			return fmt.Errorf("%w: %s has named results", ErrUnsupported, fn.Name())
+		}
+
+		sc.edits = append(sc.edits, func() {
+			dst.Inspect(dbody, func(n dst.Node) bool {
+				switch n := n.(type) {
+				case *dst.FuncLit:
+					return false
+				case *dst.ReturnStmt:
+					for _, r := range sc.results {
+						n.Results = append(n.Results, parseExpr(r.value))
+					}
+				}
+				return true
+			})
+
+			list := dbody.List
+			if len(list) == 0 {
+				dbody.List = append(dbody.List, sc.returnStmt())
+			} else if _, ok := list[len(list)-1].(*dst.ReturnStmt); !ok {
+				dbody.List = append(dbody.List, sc.returnStmt())
+			}
+		})
+	}
+	return nil
+}
+
+// collides returns an error if a new parameter called name would clash
+// with a parameter or result of the function or would shadow, or be
+// shadowed by, an identifier that the body declares or refers to
+func (sc *signatureChanger) collides(fn *types.Func, body *ast.BlockStmt, name string) error {
+	sig := fn.Type().(*types.Signature)
+	for _, tuple := range []*types.Tuple{sig.Params(), sig.Results()} {
+		for i := 0; i < tuple.Len(); i++ {
+			if tuple.At(i).Name() == name {
+				return fmt.Errorf("%w: %s already has a parameter or result named %s", ErrDeclExists, fn.Name(), name)
+			}
+		}
+	}
+
+	var err error
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// selected fields and methods can not clash
+			ast.Inspect(n.X, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && err == nil {
+					err = sc.clash(fn, id, name)
+				}
+				return err == nil
+			})
+			return false
+		case *ast.Ident:
+			if err == nil {
+				err = sc.clash(fn, n, name)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// clash returns an error if the identifier is called name and refers to
+// something other than a struct field
+func (sc *signatureChanger) clash(fn *types.Func, id *ast.Ident, name string) error {
+	if id.Name != name {
+		return nil
+	}
+
+	obj := sc.check.info.Defs[id]
+	if obj == nil {
+		obj = sc.check.info.Uses[id]
+	}
+
+	if v, ok := obj.(*types.Var); obj == nil || (ok && v.IsField()) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is already used in the body of %s", ErrDeclExists, name, fn.Name())
+}
+
+// returnStmt returns a return statement for a function that previously
+// had no results
+func (sc *signatureChanger) returnStmt() *dst.ReturnStmt {
+	ret := &dst.ReturnStmt{}
+	for _, r := range sc.results {
+		ret.Results = append(ret.Results, parseExpr(r.value))
+	}
+	return ret
+}
+
+// arguments returns the arguments for the new signature given the
+// arguments of an existing call
+func (sc *signatureChanger) arguments(sig *types.Signature, args []dst.Expr) []dst.Expr {
+	newArgs := []dst.Expr{}
+	for _, p := range sc.params {
+		switch {
+		case p.from < 0:
+			newArgs = append(newArgs, parseExpr(p.value))
+		case sig.Variadic() && p.from == sig.Params().Len()-1:
+			if p.from < len(args) {
+				newArgs = append(newArgs, args[p.from:]...)
+			}
+		default:
+			newArgs = append(newArgs, args[p.from])
+		}
+	}
+	return newArgs
+}
+
+// reference queues the changes for a reference to one of the changed
+// functions.  Calls have their arguments rewritten while function and
+// method values are wrapped in a function literal with the old signature
+func (sc *signatureChanger) reference(path []ast.Node, fn *types.Func) error {
+	sig := fn.Type().(*types.Signature)
+	ref := path[len(path)-1].(ast.Expr)
+	parent := path[len(path)-2]
+	if sel, ok := parent.(*ast.SelectorExpr); ok && sel.Sel == ref {
+		ref = sel
+		path = path[:len(path)-1]
+		parent = path[len(path)-2]
+	}
+
+	call, ok := parent.(*ast.CallExpr)
+	if !ok || call.Fun != ref {
+		return sc.adapter(path, ref, fn)
+	}
+
+	dcall := sc.check.nodes.Dst.Nodes[call].(*dst.CallExpr)
+	if len(call.Args) == 1 && sig.Params().Len() > 1 {
+		return fmt.Errorf("%w: call with multi-value argument", ErrUnsupported)
+	}
+
+	for i := range call.Args {
+		removed := true
+		for _, p := range sc.params {
+			removed = removed && p.from != i && !(sig.Variadic() && p.from == sig.Params().Len()-1 && i >= p.from)
+		}
+
+		if removed && (&inliner{check: sc.check}).impure(call.Args[i]) {
+			return fmt.Errorf("%w: removed argument has side effects", ErrUnsupported)
+		}
+	}
+
+	// arguments that trade places must not change the order in which
+	// side effects happen, unless one of them is a constant
+	for i, p := range sc.params {
+		for _, q := range sc.params[i+1:] {
+			if p.from < 0 || q.from < 0 || p.from < q.from || p.from >= len(call.Args) {
+				continue
+			}
+
+			a, b := call.Args[q.from], call.Args[p.from]
+			if sc.reorderable(a) || sc.reorderable(b) {
+				continue
+			}
+
+			in := &inliner{check: sc.check}
+			if in.impure(a) || in.impure(b) {
+				return fmt.Errorf("%w: reordering the arguments of %s would change the order of their side effects", ErrUnsupported, fn.Name())
+			}
+		}
+	}
+
+	if len(sc.results) > 0 {
+		ctx := path[len(path)-3]
+		switch c := ctx.(type) {
+		case *ast.ExprStmt, *ast.GoStmt, *ast.DeferStmt:
+		case *ast.AssignStmt:
+			if len(c.Rhs) != 1 {
+				return fmt.Errorf("%w: call to %s is part of a multiple assignment", ErrUnsupported, fn.Name())
+			}
+
+			as := sc.check.nodes.Dst.Nodes[c].(*dst.AssignStmt)
+			sc.edits = append(sc.edits, func() {
+				for range sc.results {
+					as.Lhs = append(as.Lhs, dst.NewIdent("_"))
+				}
+			})
+		default:
+			if sig.Results().Len() > 0 {
+				return fmt.Errorf("%w: results of %s are used in an expression", ErrUnsupported, fn.Name())
+			}
+		}
+	}
+
+	sc.edits = append(sc.edits, func() {
+		dcall.Args = sc.arguments(sig, dcall.Args)
+		if len(dcall.Args) > 0 && dcall.Ellipsis && !sig.Variadic() {
+			dcall.Ellipsis = false
+		}
+	})
+	return nil
+}
+
+// reorderable determines if the argument is a constant, which can be
+// evaluated in any order relative to the other arguments
+func (sc *signatureChanger) reorderable(arg ast.Expr) bool {
+	return sc.check.info.Types[arg].Value != nil
+}
+
+// adapter wraps a function value in a function literal that has the
+// old signature and calls the function with the new one
+func (sc *signatureChanger) adapter(path []ast.Node, ref ast.Expr, fn *types.Func) error {
+	if (&inliner{check: sc.check}).impure(ref) {
+		return fmt.Errorf("%w: method value of %s has side effects", ErrUnsupported, fn.Name())
+	}
+
+	if sel, ok := ref.(*ast.SelectorExpr); ok {
+		if s := sc.check.info.Selections[sel]; s != nil && s.Kind() == types.MethodExpr {
+			return fmt.Errorf("%w: method expression of %s", ErrUnsupported, fn.Name())
+		}
+	}
+
+	sig := sc.check.info.TypeOf(ref).(*types.Signature)
+	lit := &dst.FuncLit{
+		Type: &dst.FuncType{Func: true, Params: &dst.FieldList{Opening: true, Closing: true}},
+		Body: &dst.BlockStmt{},
+	}
+
+	args := []dst.Expr{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		name := fmt.Sprintf("p%d", i)
+		typ := sc.check.typeExpr(sig.Params().At(i).Type())
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			typ = &dst.Ellipsis{Elt: sc.check.typeExpr(sig.Params().At(i).Type().(*types.Slice).Elem())}
+		}
+		lit.Type.Params.List = append(lit.Type.Params.List, &dst.Field{Names: []*dst.Ident{dst.NewIdent(name)}, Type: typ})
+		args = append(args, dst.NewIdent(name))
+	}
+
+	if sig.Results().Len() > 0 {
+		lit.Type.Results = &dst.FieldList{Opening: sig.Results().Len() > 1, Closing: sig.Results().Len() > 1}
+		for i := 0; i < sig.Results().Len(); i++ {
+			lit.Type.Results.List = append(lit.Type.Results.List, &dst.Field{Type: sc.check.typeExpr(sig.Results().At(i).Type())})
+		}
+	}
+
+	dref := sc.check.nodes.Dst.Nodes[ref].(dst.Expr)
+	call := &dst.CallExpr{Fun: dst.Clone(dref).(dst.Expr), Args: sc.arguments(sig, args), Ellipsis: sig.Variadic()}
+	switch {
+	case len(sc.results) > 0 && sig.Results().Len() > 0:
+		lhs := []dst.Expr{}
+		rets := []dst.Expr{}
+		for i := 0; i < sig.Results().Len(); i++ {
+			lhs = append(lhs, dst.NewIdent(fmt.Sprintf("r%d", i)))
+			rets = append(rets, dst.NewIdent(fmt.Sprintf("r%d", i)))
+		}
+		for range sc.results {
+			lhs = append(lhs, dst.NewIdent("_"))
+		}
+		lit.Body.List = []dst.Stmt{
+			&dst.AssignStmt{Lhs: lhs, Tok: token.DEFINE, Rhs: []dst.Expr{call}},
+			&dst.ReturnStmt{Results: rets},
+		}
+	case sig.Results().Len() > 0:
+		lit.Body.List = []dst.Stmt{&dst.ReturnStmt{Results: []dst.Expr{call}}}
+	default:
+		lit.Body.List = []dst.Stmt{&dst.ExprStmt{X: call}}
+	}
+
+	parent := sc.check.nodes.Dst.Nodes[path[len(path)-2]]
+	sc.edits = append(sc.edits, func() {
+		replaceChild(parent, dref, lit)
+	})
+	return nil
+}
+
+// change computes and then applies the changes to the function
+// declarations and their references
+func (sc *signatureChanger) change(fn *types.Func) error {
+	sig := fn.Type().(*types.Signature)
+	if err := sc.validate(sig); err != nil {
+		return err
+	}
+
+	sc.related(fn)
+	for f := range sc.funcs {
+		if !types.Identical(f.Type().(*types.Signature).Params(), sig.Params()) || !types.Identical(f.Type().(*types.Signature).Results(), sig.Results()) {
+			return fmt.Errorf("%w: %s has a different signature than %s", ErrUnsupported, f.FullName(), fn.FullName())
+		}
+
+		if err := sc.declaration(f); err != nil {
+			return err
+		}
+	}
+
+	for _, filename := range sortedFilenames(sc.check.files) {
+		file := sc.check.files[filename]
+		var err error
+		ast.Inspect(file, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && err == nil {
+				if f, ok := sc.check.info.Uses[id].(*types.Func); ok && sc.funcs[f] {
+					err = sc.reference(enclosing(file, id), f)
+				}
+			}
+			return err == nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, edit := range sc.edits {
+		edit()
+	}
+	return nil
+}
+
+// exprString returns the source representation of an expression
+func exprString(expr dst.Expr) string {
+	file := &dst.File{
+		Name: dst.NewIdent("p"),
+		Decls: []dst.Decl{&dst.GenDecl{
+			Tok:   token.VAR,
+			Specs: []dst.Spec{&dst.ValueSpec{Names: []*dst.Ident{dst.NewIdent("_")}, Type: dst.Clone(expr).(dst.Expr)}},
+		}},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := decorator.Fprint(buf, file); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(buf.String(), "package p\n\nvar _ "))
+}
+
+// replaceChild replaces the child node old of parent with n
+func replaceChild(parent dst.Node, old, n dst.Expr) {
+	dstutil.Apply(parent, func(cursor *dstutil.Cursor) bool {
+		if cursor.Node() == old {
+			cursor.Replace(n)
+			return false
+		}
+		return true
+	}, nil)
+}
+
+// changeSignature applies the changes to the function called name or,
+// if receiver is given, the method of the receiver type
+func (f *Tools) changeSignature(name, receiver string, params func(sig *types.Signature) ([]paramSpec, error), results []resultSpec) error {
+	defer f.operation()()
+	check := f.edit()
+	fn := lookupFunc(check, name, receiver)
+	if fn == nil && receiver != "" {
+		if tn, ok := check.pkg.Scope().Lookup(receiver).(*types.TypeName); ok {
+			obj, _, _ := types.LookupFieldOrMethod(tn.Type(), false, check.pkg, name)
+			fn, _ = obj.(*types.Func)
+		}
+	}
+
+	if fn == nil {
+		return fmt.Errorf("%q: %w", name, ErrDeclNotFound)
+	}
+
+	specs, err := params(fn.Type().(*types.Signature))
+	if err != nil {
+		return err
+	}
+
+	sc := &signatureChanger{
+		check:   check,
+		params:  specs,
+		results: results,
+	}
+
+	starts := f.snapshot()
+	err = sc.change(fn)
+	if rerr := f.recordAll(starts); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// parseExprs makes sure that the types and values given for a new
+// parameter or result parse before the source is changed
+func parseExprs(exprs ...string) error {
+	for _, expr := range exprs {
+		if _, err := parser.ParseExpr(expr); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrInvalidExpr, expr, err)
+		}
+	}
+	return nil
+}
+
+// keep returns parameter specs that keep every existing parameter
+// in its current position
+func keep(sig *types.Signature) ([]paramSpec, error) {
+	params := []paramSpec{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, paramSpec{from: i})
+	}
+	return params, nil
+}
+
+// AddParam inserts a new parameter called param, of type typ, into the
+// signature of the function called name (or the method name of the
+// receiver type) at index.  Every call is passed value for the new
+// parameter.  Interface methods and the other implementations of those
+// interfaces in the package are changed along with the function
+func (f *Tools) AddParam(name, receiver string, index int, param, typ, value string) error {
+	if err := parseExprs(typ, value); err != nil {
+		return err
+	}
+	return f.changeSignature(name, receiver, func(sig *types.Signature) ([]paramSpec, error) {
+		params, _ := keep(sig)
+		if index < 0 || index > len(params) {
+			return nil, fmt.Errorf("%w: invalid parameter index %d", ErrInvalidRange, index)
+		}
+		spec := paramSpec{from: -1, name: param, typ: typ, value: value}
+		return append(params[:index], append([]paramSpec{spec}, params[index:]...)...), nil
+	}, nil)
+}
+
+// AddResult appends a result of type typ to the signature of the
+// function called name (or the method name of the receiver type).  The
+// existing return statements return value for the new result and calls
+// that assign the results of the function discard it
+func (f *Tools) AddResult(name, receiver string, typ, value string) error {
+	if err := parseExprs(typ, value); err != nil {
+		return err
+	}
+	return f.changeSignature(name, receiver, keep, []resultSpec{{typ: typ, value: value}})
+}
+
+// RemoveParam removes the parameter at index from the signature of the
+// function called name (or the method name of the receiver type) along
+// with the corresponding argument of every call.  The parameter must not
+// be used by the function
+func (f *Tools) RemoveParam(name, receiver string, index int) error {
+	return f.changeSignature(name, receiver, func(sig *types.Signature) ([]paramSpec, error) {
+		params, _ := keep(sig)
+		if index < 0 || index >= len(params) {
+			return nil, fmt.Errorf("%w: invalid parameter index %d", ErrInvalidRange, index)
+		}
+		return append(params[:index], params[index+1:]...), nil
+	}, nil)
+}
+
+// ReorderParams rearranges the parameters of the function called name
+// (or the method name of the receiver type) and the arguments of every
+// call.  The order lists the current index of each parameter in its new
+// position
+func (f *Tools) ReorderParams(name, receiver string, order ...int) error {
+	return f.changeSignature(name, receiver, func(sig *types.Signature) ([]paramSpec, error) {
+		if len(order) != sig.Params().Len() {
+			return nil, fmt.Errorf("%w: %d parameters ordered, %d wanted", ErrInvalidRange, len(order), sig.Params().Len())
+		}
+
+		params := []paramSpec{}
+		for _, from := range order {
+			params = append(params, paramSpec{from: from})
+		}
+		return params, nil
+	}, nil)
+}
@@ -0,0 +1,180 @@
+package tools
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+
+	"github.com/dave/dst"
+)
+
+// stubber generates the methods that a named type is missing in order
+// to implement an interface
+type stubber struct {
+	check   *checked
+	named   *types.Named
+	pointer bool
+	iface   *types.Named
+}
+
+// lookupInterface finds the named interface.  Names qualified with an
+// import path, ie: "io.Reader" or "example.com/pkg.Iface", are imported
+// while unqualified names are looked up in the checked package
+func (f *Tools) lookupInterface(check *checked, name string) (*types.Named, error) {
+	scope := check.pkg.Scope()
+	if i := strings.LastIndex(name, "."); i > strings.LastIndex(name, "/") {
+		path := name[:i]
+		name = name[i+1:]
+		if path != f.path && path != check.pkg.Path() {
+			pkg, err := f.importer.Import(path)
+			if err != nil {
+				return nil, err
+			}
+			scope = pkg.Scope()
+		}
+	}
+
+	if obj, ok := scope.Lookup(name).(*types.TypeName); ok {
+		if named, ok := obj.Type().(*types.Named); ok && types.IsInterface(named) {
+			return named, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: interface %s", ErrDeclNotFound, name)
+}
+
+// receiverName returns the name used for the receiver of the type's
+// methods.  The first named receiver of the existing methods is used,
+// otherwise the name is the lower cased first letter of the type name
+func receiverName(named *types.Named) string {
+	for i := 0; i < named.NumMethods(); i++ {
+		recv := named.Method(i).Type().(*types.Signature).Recv()
+		if recv.Name() != "" && recv.Name() != "_" {
+			return recv.Name()
+		}
+	}
+	return string(unicode.ToLower([]rune(named.Obj().Name())[0]))
+}
+
+// receiver determines the receiver name and style that the stubs
+// should use, following the existing methods of the type
+func (s *stubber) receiver() (name string, pointer bool) {
+	pointer = s.pointer
+	if s.pointer && s.named.NumMethods() > 0 {
+		// a value receiver is in the method set of the pointer
+		_, pointer = s.named.Method(0).Type().(*types.Signature).Recv().Type().(*types.Pointer)
+	}
+	return receiverName(s.named), pointer
+}
+
+// missing returns the interface methods that the type does not
+// implement.  A method that exists with a different signature, or
+// that is only in the pointer's method set when the value must
+// implement the interface, can not be stubbed
+func (s *stubber) missing() (missing []*types.Func, err error) {
+	var typ types.Type = s.named
+	if s.pointer {
+		typ = types.NewPointer(s.named)
+	}
+
+	iface := s.iface.Underlying().(*types.Interface)
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		if !m.Exported() && m.Pkg() != s.check.pkg {
+			return nil, fmt.Errorf("%w: %s.%s", ErrUnexported, s.iface.Obj().Name(), m.Name())
+		}
+
+		obj, _, _ := types.LookupFieldOrMethod(typ, false, s.check.pkg, m.Name())
+		switch obj := obj.(type) {
+		case nil:
+			if ptrObj, _, _ := types.LookupFieldOrMethod(types.NewPointer(s.named), false, s.check.pkg, m.Name()); ptrObj != nil {
+				return nil, fmt.Errorf("%w: %s has a pointer receiver", ErrUnsupported, m.Name())
+			}
+			missing = append(missing, m)
+		case *types.Func:
+			if !types.Identical(obj.Type().(*types.Signature), m.Type().(*types.Signature)) {
+				return nil, fmt.Errorf("%w: %s has the wrong signature", ErrUnsupported, m.Name())
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s is a field", ErrUnsupported, m.Name())
+		}
+	}
+	return missing, nil
+}
+
+// stub generates a method declaration for m that panics when called.
+// Packages referenced by the method's signature are imported into file
+func (s *stubber) stub(m *types.Func, file *dst.File, recvName string, pointer bool) *dst.FuncDecl {
+	qualifier := func(pkg *types.Package) string {
+		if pkg == s.check.pkg {
+			return ""
+		}
+		return addImport(file, pkg.Name(), pkg.Path())
+	}
+
+	var recvType dst.Expr = dst.NewIdent(s.named.Obj().Name())
+	if pointer {
+		recvType = &dst.StarExpr{X: recvType}
+	}
+
+	fn := &dst.FuncDecl{
+		Recv: &dst.FieldList{List: []*dst.Field{{
+			Names: []*dst.Ident{dst.NewIdent(recvName)},
+			Type:  recvType,
+		}}},
+		Name: dst.NewIdent(m.Name()),
+		Type: parseExpr(types.TypeString(m.Type(), qualifier)).(*dst.FuncType),
+		Body: &dst.BlockStmt{List: []dst.Stmt{
+			&dst.ExprStmt{X: &dst.CallExpr{
+				Fun:  dst.NewIdent("panic"),
+				Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: `"not implemented"`}},
+			}},
+		}},
+	}
+	fn.Type.Func = true
+	fn.Body.List[0].Decorations().Before = dst.NewLine
+	fn.Body.List[0].Decorations().After = dst.NewLine
+	return fn
+}
+
+// Implement adds stub methods to the type, or to its pointer if typ is
+// prefixed with "*", so that it implements the interface iface, which is
+// declared in the package or qualified by its import path, ie:
+// "io.ReadWriteCloser"
+func (f *Tools) Implement(typ, iface string) ([]byte, error) {
+	defer f.operation()()
+	check := f.edit()
+	s := &stubber{check: check, pointer: strings.HasPrefix(typ, "*")}
+	typ = strings.TrimPrefix(typ, "*")
+
+	obj, ok := check.pkg.Scope().Lookup(typ).(*types.TypeName)
+	if ok {
+		s.named, ok = obj.Type().(*types.Named)
+	}
+
+	if !ok || types.IsInterface(s.named) {
+		return nil, fmt.Errorf("%w: type %s", ErrDeclNotFound, typ)
+	}
+
+	var err error
+	s.iface, err = f.lookupInterface(check, iface)
+	if err != nil {
+		return nil, err
+	}
+
+	missing, err := s.missing()
+	if err != nil {
+		return nil, err
+	}
+
+	filename := check.fset.File(obj.Pos()).Name()
+	file := f.dfiles[filename]
+	recvName, pointer := s.receiver()
+	return f.format(filename, func() {
+		o := &organizer{file: file}
+		for _, m := range missing {
+			o.insert(s.stub(m, file, recvName, pointer))
+		}
+	})
+}
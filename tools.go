@@ -4,18 +4,33 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"go/format"
+	"go/build"
+	"go/importer"
+	"go/token"
+	"go/types"
 	"io/fs"
-	"io/ioutil"
-	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
 )
 
 var (
+	ErrDeclExists      = errors.New("Declaration already exists")
 	ErrDeclNotFound    = errors.New("Declaration not found")
+	ErrImportCycle     = errors.New("Import cycle not allowed")
+	ErrInvalidExpr     = errors.New("Invalid expression")
+	ErrInvalidRange    = errors.New("Invalid source range")
+	ErrNoHistory       = errors.New("No operation to undo or redo")
+	ErrNoImportPath    = errors.New("Import path has not been set")
+	ErrNoTransaction   = errors.New("No transaction in progress")
+	ErrNotEquivalent   = errors.New("Transformation changed the package")
 	ErrPackageMismatch = errors.New("Different package declarations found")
+	ErrUnexported      = errors.New("Unexported identifier referenced outside of its package")
+	ErrUnsupported     = errors.New("Unsupported declaration")
+	ErrValueChanged    = errors.New("Constant value changed")
 )
 
 func typStr(expr interface{}) (str string) {
@@ -44,16 +59,44 @@ type Change struct {
 	Current  []byte
 }
 
+// LineRange is an inclusive range of the lines of a file, numbered
+// from 1
+type LineRange struct {
+	Start int
+	End   int
+}
+
 type Tools struct {
-	changed map[string]Change
-	dfiles  map[string]*dst.File
-	pkgname string
+	changed     map[string]Change
+	concurrency int
+	current     step
+	depth       int
+	dfiles      map[string]*dst.File
+	fsys        fs.FS
+	future      []step
+	history     []step
+	importer    types.Importer
+	imports     ImportRules
+	index       *importIndex
+	mu          sync.Mutex // guards changed and current
+	order       ValueOrder
+	overlay     map[string][]byte
+	path        string
+	pkgname     string
+	savepoints  []savepoint
+	sizes       types.Sizes
+	split       bool
+	verify      Verification
 }
 
 func New() *Tools {
 	f := &Tools{
-		changed: make(map[string]Change),
-		dfiles:  make(map[string]*dst.File),
+		changed:     make(map[string]Change),
+		concurrency: runtime.GOMAXPROCS(0),
+		dfiles:      make(map[string]*dst.File),
+		importer:    importer.Default(),
+		index:       &importIndex{},
+		sizes:       types.SizesFor("gc", build.Default.GOARCH),
 	}
 	return f
 }
@@ -63,13 +106,19 @@ func New() *Tools {
 // file set then fs.ErrExist is returned. Otherwise any parse
 // errors are returned
 func (f *Tools) Add(filename string, src []byte) error {
+	dstFile, err := decorator.Parse(src)
+	return f.add(filename, dstFile, err)
+}
+
+// add adds the parsed file to the file set unless the file is already
+// in it or could not be parsed
+func (f *Tools) add(filename string, dstFile *dst.File, err error) error {
 	if _, found := f.dfiles[filename]; found {
 		return fs.ErrExist
 	}
 
-	err := f.parse(filename, src)
 	if err == nil {
-		dstFile := f.dfiles[filename]
+		f.dfiles[filename] = dstFile
 		pkgname := dstFile.Name.Name
 		if f.pkgname == "" {
 			f.pkgname = pkgname
@@ -80,9 +129,11 @@ func (f *Tools) Add(filename string, src []byte) error {
 	return err
 }
 
-// AddFile will read the file and add it to the local fileset
+// AddFile will read the file and add it to the local fileset.
+// The content is taken from the overlay, if the file is in it,
+// otherwise the file is read from the file system set by SetFS
 func (f *Tools) AddFile(filename string) error {
-	src, err := ioutil.ReadFile(filename)
+	src, err := f.readFile(filename)
 	if err == nil {
 		err = f.Add(filename, src)
 	}
@@ -90,72 +141,81 @@ func (f *Tools) AddFile(filename string) error {
 }
 
 // AddDir will add all the *.go files in the given directory
-// to the local file set.  Each file will be parsed.  If any
-// parsing errors occur processing stops and the associated
-// error is returned
+// to the local file set, including files that only exist in
+// the overlay.  Each file will be parsed.  If any parsing
+// errors occur processing stops and the associated error is
+// returned
 func (f *Tools) AddDir(dir string) error {
-	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	files, err := f.glob(dir)
 	if err == nil {
 		err = f.AddFiles(files...)
 	}
 	return err
 }
 
-// AddFiles will add all the files supplied
+// AddFiles will add all the files supplied.  The files are read and
+// parsed concurrently, see SetConcurrency, and then added in the order
+// given.  If a file can not be added, the files that follow it are not
 func (f *Tools) AddFiles(files ...string) (err error) {
+	parsed := make([]*dst.File, len(files))
+	errs := make([]error, len(files))
+	parallel(f.concurrency, len(files), func(i int) {
+		var src []byte
+		src, errs[i] = f.readFile(files[i])
+		if errs[i] == nil {
+			parsed[i], errs[i] = decorator.Parse(src)
+		}
+	})
+
 	for i := 0; i < len(files) && err == nil; i++ {
-		err = f.AddFile(files[i])
+		err = f.add(files[i], parsed[i], errs[i])
 	}
 	return
 }
 
 // Changes returns a slice of Change structs containing information
-// about each file that was changed
+// about each file that was changed, sorted by filename
 func (f *Tools) Changes() (changed []Change) {
-	for _, change := range f.changed {
-		changed = append(changed, change)
+	filenames := f.ChangedFiles()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, filename := range filenames {
+		changed = append(changed, f.changed[filename])
 	}
 	return changed
 }
 
-// ChangedFiles returns a list of filenames whose content has
+// ChangedFiles returns a sorted list of filenames whose content has
 // changed during the course of processing
 func (f *Tools) ChangedFiles() (changed []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	for filename := range f.changed {
 		changed = append(changed, filename)
 	}
+	sort.Strings(changed)
 	return changed
 }
 
 func (f *Tools) format(filename string, cb func()) ([]byte, error) {
-	buf := &bytes.Buffer{}
-	decorator.Fprint(buf, f.dfiles[filename])
-	start := buf.Bytes()
-
+	start := f.print(filename)
 	cb()
+	return f.record(filename, start)
+}
 
-	output := &bytes.Buffer{}
-	decorator.Fprint(output, f.dfiles[filename])
-	end, err := format.Source(output.Bytes())
-	if err != nil {
-		end = output.Bytes()
-	}
+// Files returns the sorted names of the files in the file set
+func (f *Tools) Files() []string {
+	return sortedFiles(f)
+}
 
-	if !bytes.Equal(start, end) {
-		change, found := f.changed[filename]
-		if !found {
-			change = Change{
-				Filename: filename,
-				Orig:     start,
-			}
-		}
-		change.Current = end
-		f.changed[filename] = change
-	}
-	return end, err
+// ImportPath returns the import path of the package in the file
+// set, as set by SetImportPath
+func (f *Tools) ImportPath() string {
+	return f.path
 }
 
 func (f *Tools) Organize(filename string) (output []byte, err error) {
+	defer f.operation()()
 	_, err = f.SeparateValues(filename)
 	if err != nil {
 		return
@@ -163,7 +223,8 @@ func (f *Tools) Organize(filename string) (output []byte, err error) {
 
 	output, err = f.format(filename, func() {
 		organizer := organizer{
-			file: f.dfiles[filename],
+			file:    f.dfiles[filename],
+			imports: f.imports,
 		}
 
 		f.dfiles[filename] = organizer.organize()
@@ -173,14 +234,18 @@ func (f *Tools) Organize(filename string) (output []byte, err error) {
 }
 
 func (f *Tools) OrganizeAll() (err error) {
-	filenames := []string{}
-	for filename := range f.dfiles {
-		filenames = append(filenames, filename)
-	}
-	return f.OrganizeFiles(filenames...)
+	return f.OrganizeFiles(sortedFiles(f)...)
 }
 
+// OrganizeFiles organizes each of the files.  The files are organized
+// concurrently, see SetConcurrency, with the same result as organizing
+// them one at a time, in the order given, with Organize
 func (f *Tools) OrganizeFiles(files ...string) (err error) {
+	defer f.operation()()
+	if f.concurrency > 1 && len(files) > 1 && f.organizeConcurrently(files) {
+		return nil
+	}
+
 	for _, filename := range files {
 		_, err = f.Organize(filename)
 		if err != nil {
@@ -190,6 +255,73 @@ func (f *Tools) OrganizeFiles(files ...string) (err error) {
 	return
 }
 
+// OrganizeLines organizes only the declarations, including imports,
+// that overlap the line ranges of the file.  The rest of the file is left
+// as it is
+func (f *Tools) OrganizeLines(filename string, ranges ...LineRange) ([]byte, error) {
+	defer f.operation()()
+	if _, found := f.dfiles[filename]; !found {
+		return nil, fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
+	}
+
+	check := f.edit()
+	file := f.dfiles[filename]
+	touched := &dst.File{Name: file.Name}
+	rest := []dst.Decl{}
+	for _, decl := range file.Decls {
+		if check.overlaps(decl, ranges) {
+			touched.Decls = append(touched.Decls, decl)
+		} else {
+			rest = append(rest, decl)
+		}
+	}
+
+	start := f.print(filename)
+	if len(touched.Decls) == 0 {
+		return start, nil
+	}
+
+	var before map[string]*types.Const
+	if hasValueBlocks(touched) {
+		before = check.constants(filename)
+	}
+
+	vc := &valueCleaner{
+		file:       touched,
+		check:      check,
+		order:      f.order,
+		splitNames: f.split,
+	}
+	touched = vc.separateValDecls()
+
+	// types are inserted first so that their methods join their group
+	sort.Stable(sortableSource(touched.Decls))
+	file.Decls = rest
+	o := &organizer{file: file, imports: f.imports}
+	imports := false
+	for _, decl := range touched.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			imports = true
+		}
+		o.insert(decl)
+	}
+
+	if imports {
+		o.organizeImports()
+	}
+
+	if len(before) > 0 {
+		after := f.check().constants(filename)
+		for name, c := range before {
+			if !sameConstant(c, after[name]) {
+				f.parse(filename, start)
+				return start, fmt.Errorf("%w: %s", ErrValueChanged, name)
+			}
+		}
+	}
+	return f.record(filename, start)
+}
+
 func (f *Tools) parse(filename string, src []byte) error {
 	dstFile, err := decorator.Parse(src)
 	if err == nil {
@@ -198,57 +330,293 @@ func (f *Tools) parse(filename string, src []byte) error {
 	return err
 }
 
-// SeparateValues analyzes the file and will group const and var
-// blocks by type.  SeparateValues will only manipulate declarations
-// that are within parenthesized blocks, ie:
-//   const (
-//    Int1 int = iota
-//    Int2
-//    Int3
-//    Int4
+func (f *Tools) print(filename string) []byte {
+	buf := &bytes.Buffer{}
+	decorator.Fprint(buf, f.dfiles[filename])
+	return buf.Bytes()
+}
+
+// record fixes the imports of filename, compares the current content
+// of the file with start and, if they differ, updates the change set
+// for the file
+func (f *Tools) record(filename string, start []byte) ([]byte, error) {
+	files := []*dst.File{}
+	for _, file := range f.dfiles {
+		files = append(files, file)
+	}
+
+	end, err := f.render(filename, f.dfiles[filename], declaredNames(files...))
+	f.recordChange(filename, start, end)
+	return end, err
+}
+
+// recordChange updates the change set for the file if its content
+// changed from start to end.  It is safe to call from several goroutines
+func (f *Tools) recordChange(filename string, start, end []byte) {
+	if bytes.Equal(start, end) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.save(filename, start)
+	change, found := f.changed[filename]
+	if !found {
+		change = Change{
+			Filename: filename,
+			Orig:     start,
+		}
+	}
+	change.Current = end
+	f.changed[filename] = change
+}
+
+// recordAll records the changes to each of the files in starts, in
+// filename order, and returns the first error encountered
+func (f *Tools) recordAll(starts map[string][]byte) (err error) {
+	filenames := []string{}
+	for filename := range starts {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		if _, rerr := f.record(filename, starts[filename]); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// MergeValues is the complement of SeparateValues.  Runs of adjacent
+// const or var declarations that are not parenthesized are merged into
+// blocks when they have the same explicit type or, if they are untyped,
+// when their names share a prefix, ie:
 //
-//    Str1 string = "string1"
-//    Str2        = "string2"
-//    Str3        = "string3"
-//    Str4        = "string4"
-//   )
+//	const MaxSize int = 10
+//	const MinSize int = 1
+//
+//	// ErrClosed is returned after Close
+//	var ErrClosed = errors.New("closed")
+//	var ErrEmpty = errors.New("empty")
 //
 // Becomes:
 //
-//   const (
-//     Int1 int = iota
-//     Int2
-//     Int3
-//     Int4
-//   )
+//	const (
+//		MaxSize int = 10
+//		MinSize int = 1
+//	)
 //
-//   const (
-//     Str1 string = "string1"
-//     Str2        = "string2"
-//     Str3        = "string3"
-//     Str4        = "string4"
-//   )
-func (f *Tools) SeparateValues(filename string) ([]byte, error) {
+//	var (
+//		// ErrClosed is returned after Close
+//		ErrClosed = errors.New("closed")
+//		ErrEmpty  = errors.New("empty")
+//	)
+//
+// Doc and line comments move with their declarations and comments that
+// are separated from a declaration by an empty line end the block.
+// Constants that use iota only start a block, since their value depends
+// on their position in the block
+func (f *Tools) MergeValues(filename string) ([]byte, error) {
+	defer f.operation()()
 	dfile, found := f.dfiles[filename]
 	if !found {
 		return nil, fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
 	}
 
 	output, err := f.format(filename, func() {
-		vf := &valueCleaner{
+		vc := &valueCleaner{
 			file: dfile,
 		}
 
-		f.dfiles[filename] = vf.separateValDecls()
+		f.dfiles[filename] = vc.mergeValDecls()
 	})
 	return output, err
 }
 
-// WriteFiles will write all the changed files using the supplied
-// FileWriter.  If an error is encountered processing stops and
-// the error is returned
+// SeparateValues analyzes the file and will group const and var
+// blocks by type.  SeparateValues will only manipulate declarations
+// that are within parenthesized blocks, ie:
+//
+//	const (
+//	 Int1 int = iota
+//	 Int2
+//	 Int3
+//	 Int4
+//
+//	 Str1 string = "string1"
+//	 Str2        = "string2"
+//	 Str3        = "string3"
+//	 Str4        = "string4"
+//	)
+//
+// Becomes:
+//
+//	const (
+//	  Int1 int = iota
+//	  Int2
+//	  Int3
+//	  Int4
+//	)
+//
+//	const (
+//	  Str1 string = "string1"
+//	  Str2        = "string2"
+//	  Str3        = "string3"
+//	  Str4        = "string4"
+//	)
+//
+// Specs are grouped by the type that the type checker infers for them,
+// using the default type of untyped constants, so "Retries = 3" and
+// "Interval = 2 * time.Second" are separated even without explicit
+// types.  If a spec's type is not known, its explicit type is used.
+// The specs of each block may be sorted, see SetValueOrder.
+//
+// Constant blocks are evaluated before and after they are separated so
+// that no constant's value or type changes.  A block that starts with a
+// spec that used iota, or implicitly repeated an earlier spec, is given
+// an explicit expression with iota offset by the spec's original
+// position, ie: "FlagA Flag = 1 << iota" as the third spec of a block
+// becomes "FlagA Flag = 1 << (iota + 2)".  If a value would still change
+// the file is left unchanged and ErrValueChanged is returned
+func (f *Tools) SeparateValues(filename string) ([]byte, error) {
+	defer f.operation()()
+	dfile, found := f.dfiles[filename]
+	if !found {
+		return nil, fmt.Errorf("%q: %w", filename, fs.ErrNotExist)
+	}
+
+	var check *checked
+	var before map[string]*types.Const
+	if hasValueBlocks(dfile) {
+		check = f.edit()
+		before = check.constants(filename)
+		dfile = f.dfiles[filename]
+	}
+
+	start := f.print(filename)
+	vf := &valueCleaner{
+		file:       dfile,
+		check:      check,
+		order:      f.order,
+		splitNames: f.split,
+	}
+	f.dfiles[filename] = vf.separateValDecls()
+
+	if len(before) > 0 {
+		after := f.check().constants(filename)
+		for name, c := range before {
+			if !sameConstant(c, after[name]) {
+				f.parse(filename, start)
+				return start, fmt.Errorf("%w: %s", ErrValueChanged, name)
+			}
+		}
+	}
+	return f.record(filename, start)
+}
+
+// SetArch sets the architecture, as named by GOARCH, that is used to
+// compute the size and alignment of types.  The default is the
+// architecture of the build environment
+func (f *Tools) SetArch(arch string) error {
+	sizes := types.SizesFor("gc", arch)
+	if sizes == nil {
+		return fmt.Errorf("%w: GOARCH %q", ErrUnsupported, arch)
+	}
+	f.sizes = sizes
+	return nil
+}
+
+// SetConcurrency sets the maximum number of files that are parsed or
+// organized at the same time.  The default is GOMAXPROCS and a value
+// less than 2 processes the files one at a time
+func (f *Tools) SetConcurrency(n int) {
+	f.concurrency = n
+}
+
+// SetFS sets the file system that AddFile and AddDir read from.
+// Filenames are then the slash separated paths used by fs.FS.  If
+// fsys is nil the operating system's file system is used
+func (f *Tools) SetFS(fsys fs.FS) {
+	f.fsys = fsys
+}
+
+// SetImportRules sets the rules used by Organize to arrange the imports
+// of a file
+func (f *Tools) SetImportRules(rules ImportRules) {
+	f.imports = rules
+}
+
+// SetLocalPrefix sets the import path prefix of the packages that
+// belong to the local organization.  Imports added to a file are grouped
+// into the standard library, third party and local packages.  Several
+// prefixes may be given separated by commas
+func (f *Tools) SetLocalPrefix(prefix string) {
+	f.imports.LocalPrefix = prefix
+}
+
+// SetValueOrder sets how SeparateValues orders the specs within each
+// block.  Blocks whose specs can not be reordered without changing their
+// values or order of initialization are left in declaration order
+func (f *Tools) SetValueOrder(order ValueOrder) {
+	f.order = order
+}
+
+// SetOverlay sets the content of files that replaces, or adds to, the
+// files in the file system, such as an editor's unsaved buffers.  As
+// with go/packages, the overlay maps filenames to their content.  When
+// reading from the operating system's file system, relative and
+// absolute filenames refer to the same file
+func (f *Tools) SetOverlay(overlay map[string][]byte) {
+	f.overlay = make(map[string][]byte)
+	for filename, content := range overlay {
+		f.overlay[f.overlayKey(filename)] = content
+	}
+}
+
+// SetSplitNames sets whether SeparateValues splits specs that declare
+// several names, ie: "a, b int = 1, 2", into a spec per name.  Specs are
+// only split when each name has its own value, or there are no values,
+// and, for constants, when the block does not use iota or implicit
+// repetition
+func (f *Tools) SetSplitNames(split bool) {
+	f.split = split
+}
+
+// SetVerification sets the checks that WriteFiles makes before writing
+// any files, see Verify
+func (f *Tools) SetVerification(verification Verification) {
+	f.verify = verification
+}
+
+// SetImportPath sets the import path of the package in the file
+// set.  The import path is required by operations that rewrite
+// references across package boundaries, such as Move
+func (f *Tools) SetImportPath(path string) {
+	f.path = path
+}
+
+// snapshot returns the current content of every file in the set
+func (f *Tools) snapshot() map[string][]byte {
+	snapshot := make(map[string][]byte)
+	for filename := range f.dfiles {
+		snapshot[filename] = f.print(filename)
+	}
+	return snapshot
+}
+
+// WriteFiles will write all the changed files, in filename order,
+// using the supplied FileWriter.  If an error is encountered processing stops and
+// the error is returned.  If verification has been enabled with
+// SetVerification and the changes fail it, nothing is written
 func (f *Tools) WriteFiles(writer FileWriter) (err error) {
-	for filename := range f.changed {
+	if f.verify != VerifyNone && len(f.changed) > 0 {
+		if err = f.Verify(); err != nil {
+			return err
+		}
+	}
+
+	for _, filename := range f.ChangedFiles() {
 		buf := &bytes.Buffer{}
 		err = decorator.Fprint(buf, f.dfiles[filename])
 		if err == nil {
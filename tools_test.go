@@ -2,12 +2,16 @@ package tools
 
 import (
 	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
 	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -62,12 +66,201 @@ func TestAddFile(t *testing.T) {
 
 type testFunc func(string, []byte) ([]byte, error)
 
+// sessionFunc performs an operation on a file that has been added to a
+// new session.  The arguments are taken from an "// args:" line at the
+// start of the input, ie:
+//
+//	// args: double
+type sessionFunc func(tools *Tools, filename string, args []string) error
+
+// testErrors are the errors that the *.err files of testdata/tools_test
+// may name
+var testErrors = map[string]error{
+	"ErrDeclExists":    ErrDeclExists,
+	"ErrDeclNotFound":  ErrDeclNotFound,
+	"ErrInvalidExpr":   ErrInvalidExpr,
+	"ErrInvalidRange":  ErrInvalidRange,
+	"ErrNotEquivalent": ErrNotEquivalent,
+	"ErrUnsupported":   ErrUnsupported,
+	"ErrValueChanged":  ErrValueChanged,
+}
+
+// testArgs returns the arguments given by the "// args:" line of the
+// input.  Quoted arguments are unquoted
+func testArgs(input []byte) (args []string) {
+	line := strings.SplitN(string(input), "\n", 2)[0]
+	if !strings.HasPrefix(line, "// args:") {
+		return nil
+	}
+
+	for _, arg := range strings.Fields(strings.TrimPrefix(line, "// args:")) {
+		if unquoted, err := strconv.Unquote(arg); err == nil {
+			arg = unquoted
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// testPosition parses a "line:column" argument
+func testPosition(arg string) token.Position {
+	pos := token.Position{}
+	fmt.Sscanf(arg, "%d:%d", &pos.Line, &pos.Column)
+	return pos
+}
+
+// testArg returns the i'th argument, or an empty string if there are
+// not that many
+func testArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+func newSession(t *testing.T, path string, files map[string]string) *Tools {
+	t.Helper()
+	session := New()
+	session.SetImportPath(path)
+	for filename, content := range files {
+		if err := session.Add(filename, []byte(content)); err != nil {
+			t.Fatalf("Failed to add %s: %v", filename, err)
+		}
+	}
+	return session
+}
+
+func wantSource(t *testing.T, session *Tools, filename, want string) {
+	t.Helper()
+	wantBytes, err := format.Source([]byte(want))
+	if err != nil {
+		t.Fatalf("Failed to format wanted source: %v", err)
+	}
+
+	got := string(session.print(filename))
+	if string(wantBytes) != got {
+		t.Errorf("%s: Wanted\n%s\nGot:\n%s", filename, string(wantBytes), got)
+	}
+}
+
 func TestGoTools(t *testing.T) {
 	testFuncs := map[string][]testFunc{
+		"MergeValues":    []testFunc{MergeValues},
 		"SeparateValues": []testFunc{SeparateValues},
 		"Organize":       []testFunc{Organize},
 	}
 
+	sessionFuncs := map[string]sessionFunc{
+		"AddParam": func(tools *Tools, filename string, args []string) error {
+			index, _ := strconv.Atoi(args[2])
+			return tools.AddParam(args[0], args[1], index, args[3], args[4], args[5])
+		},
+		"AddResult": func(tools *Tools, filename string, args []string) error {
+			return tools.AddResult(args[0], args[1], args[2], args[3])
+		},
+		"AlignFields": func(tools *Tools, filename string, args []string) error {
+			err := tools.SetArch(args[0])
+			if err == nil {
+				_, err = tools.AlignFields()
+			}
+			return err
+		},
+		"DeadCode": func(tools *Tools, filename string, args []string) error {
+			_, err := tools.DeadCode(true)
+			return err
+		},
+		"ExtractFunc": func(tools *Tools, filename string, args []string) error {
+			_, err := tools.ExtractFunc(filename, testPosition(args[1]), testPosition(args[2]), args[0])
+			return err
+		},
+		"ExtractInterface": func(tools *Tools, filename string, args []string) error {
+			rewrite, _ := strconv.ParseBool(args[3])
+			return tools.ExtractInterface(args[0], args[1], args[2], rewrite, args[4:]...)
+		},
+		"FixImports": func(tools *Tools, filename string, args []string) error {
+			tools.SetLocalPrefix(testArg(args, 0))
+			_, err := tools.format(filename, func() {})
+			return err
+		},
+		"GenerateConstructor": func(tools *Tools, filename string, args []string) error {
+			return tools.GenerateConstructor(args[0], args[1:]...)
+		},
+		"GenerateEnums": func(tools *Tools, filename string, args []string) error {
+			methods := EnumMethods(0)
+			for _, name := range strings.Split(args[0], "|") {
+				methods |= map[string]EnumMethods{"EnumText": EnumText, "EnumValues": EnumValues}[name]
+			}
+			return tools.GenerateEnums(methods, args[1:]...)
+		},
+		"GenerateOptions": func(tools *Tools, filename string, args []string) error {
+			return tools.GenerateOptions(args[0], args[1:]...)
+		},
+		"Implement": func(tools *Tools, filename string, args []string) error {
+			_, err := tools.Implement(args[0], args[1])
+			return err
+		},
+		"Inline": func(tools *Tools, filename string, args []string) error {
+			return tools.Inline(args[0], testArg(args, 1))
+		},
+		"InlineCall": func(tools *Tools, filename string, args []string) error {
+			_, err := tools.InlineCall(filename, testPosition(args[0]))
+			return err
+		},
+		"KeyLiterals": func(tools *Tools, filename string, args []string) error {
+			return tools.KeyLiterals(args...)
+		},
+		"OrganizeImports": func(tools *Tools, filename string, args []string) error {
+			placements := map[string]ImportPlacement{"ImportGroupEnd": ImportGroupEnd, "ImportSection": ImportSection}
+			rules := ImportRules{}
+			for _, arg := range args {
+				kv := strings.SplitN(arg, "=", 2)
+				switch kv[0] {
+				case "LocalPrefix":
+					rules.LocalPrefix = kv[1]
+				case "Named":
+					rules.Named = placements[kv[1]]
+				case "Blank":
+					rules.Blank = placements[kv[1]]
+				}
+			}
+			tools.SetImportRules(rules)
+			_, err := tools.Organize(filename)
+			return err
+		},
+		"OrganizeLines": func(tools *Tools, filename string, args []string) error {
+			ranges := []LineRange{}
+			for _, arg := range args {
+				r := LineRange{}
+				fmt.Sscanf(arg, "%d-%d", &r.Start, &r.End)
+				ranges = append(ranges, r)
+			}
+			_, err := tools.OrganizeLines(filename, ranges...)
+			return err
+		},
+		"RemoveParam": func(tools *Tools, filename string, args []string) error {
+			index, _ := strconv.Atoi(args[2])
+			return tools.RemoveParam(args[0], args[1], index)
+		},
+		"ReorderParams": func(tools *Tools, filename string, args []string) error {
+			order := []int{}
+			for _, arg := range args[2:] {
+				i, _ := strconv.Atoi(arg)
+				order = append(order, i)
+			}
+			return tools.ReorderParams(args[0], args[1], order...)
+		},
+		"SplitNames": func(tools *Tools, filename string, args []string) error {
+			tools.SetSplitNames(true)
+			_, err := tools.SeparateValues(filename)
+			return err
+		},
+		"ValueOrder": func(tools *Tools, filename string, args []string) error {
+			tools.SetValueOrder(map[string]ValueOrder{"OrderByName": OrderByName, "OrderByValue": OrderByValue}[args[0]])
+			_, err := tools.SeparateValues(filename)
+			return err
+		},
+	}
+
 	readFile := func(filename string) []byte {
 		output, err := ioutil.ReadFile(filename)
 		if err != nil {
@@ -80,40 +273,72 @@ func TestGoTools(t *testing.T) {
 	sort.Strings(inputs)
 
 	wants, _ := filepath.Glob("testdata/tools_test/*.want")
-	sort.Strings(wants)
+	errs, _ := filepath.Glob("testdata/tools_test/*.err")
+	if len(inputs) != len(wants)+len(errs) {
+		t.Fatalf("Wanted %d outputs got %d", len(inputs), len(wants)+len(errs))
+	}
+
+	run := func(name, inputfile string, input []byte) ([][]byte, []error) {
+		outputs, errs := [][]byte{}, []error{}
+		if fs, found := testFuncs[name]; found {
+			for _, f := range fs {
+				output, err := f(inputfile, input)
+				outputs, errs = append(outputs, output), append(errs, err)
+			}
+		} else if f, found := sessionFuncs[name]; found {
+			tools := New()
+			err := tools.Add(inputfile, input)
+			if err == nil {
+				err = f(tools, inputfile, testArgs(input))
+			}
 
-	if len(inputs) != len(wants) {
-		t.Fatalf("Wanted %d outputs got %d", len(inputs), len(wants))
+			output, ferr := format.Source(tools.print(inputfile))
+			if err == nil {
+				err = ferr
+			}
+			outputs, errs = append(outputs, output), append(errs, err)
+		} else {
+			t.Errorf("Unknown test function %q", name)
+		}
+		return outputs, errs
 	}
 
-	test := func(testname, inputfile, wantfile string) func(t *testing.T) {
+	test := func(testname, inputfile string) func(t *testing.T) {
 		return func(t *testing.T) {
-			want := string(readFile(wantfile))
+			base := strings.TrimSuffix(inputfile, filepath.Ext(inputfile))
 			names := strings.Split(testname, "_")
-			if fs, found := testFuncs[names[0]]; found {
-				for _, f := range fs {
-					gotBytes, err := f(inputfile, readFile(inputfile))
-					got := string(gotBytes)
-					if err != nil {
-						t.Errorf("Failed to execute %s: %v", names[0], err)
-						t.Errorf("Output:\n%s\n", got)
-					} else {
-						if want != got {
-							t.Errorf("Wanted:\n%s\n\nGot:\n%s\n", want, got)
-						}
+			outputs, errs := run(names[0], inputfile, readFile(inputfile))
+			if _, err := os.Stat(base + ".err"); err == nil {
+				// a failed operation leaves the file as it was
+				name := strings.TrimSpace(string(readFile(base + ".err")))
+				input, _ := format.Source(readFile(inputfile))
+				for i, err := range errs {
+					if !errors.Is(err, testErrors[name]) {
+						t.Errorf("Wanted error %s got %v", name, err)
+					} else if string(outputs[i]) != string(input) {
+						t.Errorf("Wanted the input unchanged got:\n%s\n", outputs[i])
 					}
 				}
-			} else {
-				t.Errorf("Unknown test function %q", names[0])
+				return
+			}
+
+			want := string(readFile(base + ".want"))
+			for i, gotBytes := range outputs {
+				got := string(gotBytes)
+				if errs[i] != nil {
+					t.Errorf("Failed to execute %s: %v", names[0], errs[i])
+					t.Errorf("Output:\n%s\n", got)
+				} else if want != got {
+					t.Errorf("Wanted:\n%s\n\nGot:\n%s\n", want, got)
+				}
 			}
 		}
 	}
 
-	for i, inputfile := range inputs {
+	for _, inputfile := range inputs {
 		testname := strings.TrimSuffix(filepath.Base(inputfile), filepath.Ext(inputfile))
-		t.Run(testname, test(testname, inputfile, wants[i]))
+		t.Run(testname, test(testname, inputfile))
 	}
-
 }
 
 func TestWriteFiles(t *testing.T) {
@@ -182,3 +407,66 @@ func TestWriteFiles(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err)
 	}
 }
+
+func TestOrganizeIdempotent(t *testing.T) {
+	inputs, _ := filepath.Glob("testdata/tools_test/*.input")
+	more, _ := filepath.Glob("testdata/write_files_test/input/*.go")
+	inputs = append(inputs, more...)
+	sort.Strings(inputs)
+
+	for _, inputfile := range inputs {
+		t.Run(filepath.Base(inputfile), func(t *testing.T) {
+			input, err := ioutil.ReadFile(inputfile)
+			if err != nil {
+				t.Fatalf("Failed to read file %s: %v", inputfile, err)
+			}
+
+			once, err := Organize(inputfile, input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			twice, err := Organize(inputfile, once)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if string(once) != string(twice) {
+				t.Errorf("Organizing again changed the output from:\n%s\nto:\n%s", once, twice)
+			}
+		})
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	tools := New()
+	if err := tools.AddDir("testdata/write_files_test/input"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := tools.OrganizeAll(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	written := []string{}
+	tools.WriteFiles(func(filename string, data []byte) error {
+		written = append(written, filename)
+		return nil
+	})
+
+	changes := []string{}
+	for _, change := range tools.Changes() {
+		changes = append(changes, change.Filename)
+	}
+
+	changed := tools.ChangedFiles()
+	if !sort.StringsAreSorted(changed) {
+		t.Errorf("Wanted sorted changed files got %v", changed)
+	}
+
+	for name, got := range map[string][]string{"Changes": changes, "WriteFiles": written} {
+		if strings.Join(got, " ") != strings.Join(changed, " ") {
+			t.Errorf("%s: wanted %v got %v", name, changed, got)
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package tools
 
 import (
+	"fmt"
+	"go/ast"
+	"go/constant"
 	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+	"unicode"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/dstutil"
@@ -17,38 +24,294 @@ func SeparateValues(filename string, input []byte) (output []byte, err error) {
 	return
 }
 
+func MergeValues(filename string, input []byte) (output []byte, err error) {
+	tools := New()
+	err = tools.Add(filename, input)
+
+	if err == nil {
+		output, err = tools.MergeValues(filename)
+	}
+	return
+}
+
+// ValueOrder selects how SeparateValues orders the specs within each
+// of the blocks it produces
+type ValueOrder int
+
+const (
+	// OrderDeclared keeps the specs in the order they are declared
+	OrderDeclared ValueOrder = iota
+
+	// OrderByName sorts the specs by name
+	OrderByName
+
+	// OrderByValue sorts the specs by their constant value, or by the
+	// source of their value when it is not constant
+	OrderByValue
+)
+
 type valueCleaner struct {
-	file *dst.File
+	file       *dst.File
+	check      *checked
+	order      ValueOrder
+	splitNames bool
+}
+
+// specType returns the name of the type that the spec is grouped by.
+// The type of each name is inferred by the type checker, using the
+// default type of untyped constants.  If the type is not known then the
+// spec's explicit type is used, and without one, the spec is grouped
+// with the previous spec, whose type is last
+func (vc *valueCleaner) specType(vs *dst.ValueSpec, last string) string {
+	if vc.check != nil {
+		names := []string{}
+		for _, name := range vs.Names {
+			obj := vc.check.object(name)
+			if obj == nil || obj.Type() == types.Typ[types.Invalid] {
+				names = nil
+				break
+			}
+
+			typ := types.TypeString(types.Default(obj.Type()), vc.check.qualifier)
+			if len(names) == 0 || names[len(names)-1] != typ {
+				names = append(names, typ)
+			}
+		}
+
+		if len(names) > 0 {
+			return strings.Join(names, ", ")
+		}
+	}
+
+	if vs.Type != nil {
+		return exprString(vs.Type)
+	}
+	return last
+}
+
+// isConversion determines if the call converts its argument to a type
+func (vc *valueCleaner) isConversion(call *dst.CallExpr) bool {
+	if vc.check != nil {
+		if fun, found := vc.check.nodes.Ast.Nodes[call.Fun]; found {
+			return vc.check.info.Types[fun.(ast.Expr)].IsType()
+		}
+	}
+	return false
+}
+
+// sortable determines if the specs of the block can be reordered
+// without changing their values.  Constants must not use iota or
+// implicit repetition and variables must not be initialized by function
+// calls, since reordering them could change the order of initialization
+func (vc *valueCleaner) sortable(decl *dst.GenDecl) bool {
+	for _, spec := range decl.Specs {
+		vs := spec.(*dst.ValueSpec)
+		if decl.Tok == token.CONST && (len(vs.Values) == 0 || usesIota(vs)) {
+			return false
+		}
+
+		for _, value := range vs.Values {
+			found := false
+			dst.Inspect(value, func(n dst.Node) bool {
+				if call, ok := n.(*dst.CallExpr); ok && !vc.isConversion(call) {
+					found = true
+				}
+				return !found
+			})
+
+			if found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// constValue returns the constant value of the spec's first name, or
+// nil if it is not a constant that can be ordered
+func (vc *valueCleaner) constValue(vs *dst.ValueSpec) constant.Value {
+	if vc.check != nil {
+		if c, ok := vc.check.object(vs.Names[0]).(*types.Const); ok {
+			switch c.Val().Kind() {
+			case constant.Int, constant.Float, constant.String:
+				return c.Val()
+			}
+		}
+	}
+	return nil
+}
+
+// less determines if spec a is ordered before spec b
+func (vc *valueCleaner) less(a, b *dst.ValueSpec) bool {
+	if vc.order == OrderByValue {
+		va, vb := vc.constValue(a), vc.constValue(b)
+		if va != nil && vb != nil && (va.Kind() == constant.String) == (vb.Kind() == constant.String) {
+			return constant.Compare(va, token.LSS, vb)
+		}
+
+		if len(a.Values) > 0 && len(b.Values) > 0 {
+			return exprString(a.Values[0]) < exprString(b.Values[0])
+		}
+	}
+	return a.Names[0].Name < b.Names[0].Name
+}
+
+// sortSpecs orders the specs of the block, if they can be reordered.
+// Specs with doc comments are separated from the previous spec by an
+// empty line
+func (vc *valueCleaner) sortSpecs(decl *dst.GenDecl) {
+	if vc.order == OrderDeclared || !vc.sortable(decl) {
+		return
+	}
+
+	sort.SliceStable(decl.Specs, func(i, j int) bool {
+		return vc.less(decl.Specs[i].(*dst.ValueSpec), decl.Specs[j].(*dst.ValueSpec))
+	})
+
+	for i, spec := range decl.Specs {
+		decs := spec.Decorations()
+		decs.Before = dst.NewLine
+		decs.After = dst.NewLine
+		if i > 0 && len(decs.Start) > 0 {
+			decs.Before = dst.EmptyLine
+		}
+	}
+}
+
+// offsetIota rewrites the references to iota in the values so that
+// they evaluate as they did when the spec was at the index within its
+// block
+func offsetIota(values []dst.Expr, index int) {
+	offset := func() dst.Expr {
+		return parseExpr(fmt.Sprintf("iota + %d", index))
+	}
+
+	for i, value := range values {
+		if id, ok := value.(*dst.Ident); ok && id.Name == "iota" {
+			values[i] = offset()
+			continue
+		}
+
+		values[i] = dstutil.Apply(value, func(cursor *dstutil.Cursor) bool {
+			if id, ok := cursor.Node().(*dst.Ident); ok && id.Name == "iota" {
+				cursor.Replace(&dst.ParenExpr{X: offset()})
+			}
+			return true
+		}, nil).(dst.Expr)
+	}
+}
+
+// anchor prepares a const spec, at the index within its block, to start
+// a new block.  If the spec implicitly repeats the type and values of
+// the inherited spec they are made explicit.  References to iota are
+// offset so the spec's values do not change
+func anchor(spec, inherited *dst.ValueSpec, index int) {
+	if len(spec.Values) == 0 && inherited != nil {
+		if inherited.Type != nil {
+			spec.Type = dst.Clone(inherited.Type).(dst.Expr)
+		}
+
+		for _, value := range inherited.Values {
+			spec.Values = append(spec.Values, dst.Clone(value).(dst.Expr))
+		}
+	}
+
+	if index > 0 && usesIota(spec) {
+		offsetIota(spec.Values, index)
+	}
+}
+
+// splittable determines if the multi-name specs of the block can be
+// split into a spec per name.  Each spec must have a value per name, or
+// no values at all, and const blocks must not use iota or implicit
+// repetition since splitting changes the position of the specs
+func splittable(decl *dst.GenDecl) bool {
+	for _, spec := range decl.Specs {
+		vs := spec.(*dst.ValueSpec)
+		if len(vs.Values) != 0 && len(vs.Values) != len(vs.Names) {
+			return false
+		}
+
+		if decl.Tok == token.CONST && (len(vs.Values) == 0 || usesIota(vs)) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitNames returns a spec for each name of the spec.  The doc comment
+// stays with the first name and the line comment with the last
+func splitNames(vs *dst.ValueSpec) (specs []dst.Spec) {
+	for i, name := range vs.Names {
+		spec := &dst.ValueSpec{Names: []*dst.Ident{name}, Type: vs.Type}
+		if i > 0 && vs.Type != nil {
+			spec.Type = dst.Clone(vs.Type).(dst.Expr)
+		}
+
+		if len(vs.Values) > 0 {
+			spec.Values = []dst.Expr{vs.Values[i]}
+		}
+
+		spec.Decs.Before = dst.NewLine
+		spec.Decs.After = dst.NewLine
+		if i == 0 {
+			spec.Decs.Before = vs.Decs.Before
+			spec.Decs.Start = vs.Decs.Start
+		}
+
+		if i == len(vs.Names)-1 {
+			spec.Decs.After = vs.Decs.After
+			spec.Decs.End = vs.Decs.End
+		}
+		specs = append(specs, spec)
+	}
+	return specs
 }
 
 func (vc *valueCleaner) separateValDecl(decl *dst.GenDecl) (results []dst.Node) {
 	// only refactor parenthesized decalarations
 	if decl.Lparen {
+		if vc.splitNames && splittable(decl) {
+			specs := []dst.Spec{}
+			for _, spec := range decl.Specs {
+				specs = append(specs, splitNames(spec.(*dst.ValueSpec))...)
+			}
+			decl.Specs = specs
+		}
+
 		lastType := ""
+		var inherited *dst.ValueSpec
 		newDecl := &dst.GenDecl{Tok: decl.Tok, Lparen: true, Rparen: true, Decs: decl.Decs}
-		for _, spec := range decl.Specs {
+		for i, spec := range decl.Specs {
 			vs := spec.(*dst.ValueSpec)
-			if len(vs.Names) < 2 {
-				if lastType == "" {
-					lastType = typStr(vs.Type)
-				}
+			typ := vc.specType(vs, lastType)
 
-				// check if the next spec type is different than
-				// the previous, and if so, close the block
-				// and start a new one
-				if vs.Type != nil && lastType != typStr(vs.Type) {
-					// end the block and start a new one
-					// with the next spec
-					lastType = typStr(vs.Type)
-					newDecl.Specs[len(newDecl.Specs)-1].Decorations().After = dst.None
-					results = append(results, newDecl)
-					newDecl = &dst.GenDecl{Tok: decl.Tok, Lparen: true, Rparen: true}
-					spec.Decorations().Before = dst.NewLine
+			// check if the next spec type is different than
+			// the previous, and if so, close the block
+			// and start a new one
+			if i > 0 && lastType != typ {
+				// end the block and start a new one
+				// with the next spec
+				newDecl.Specs[len(newDecl.Specs)-1].Decorations().After = dst.None
+				results = append(results, newDecl)
+				newDecl = &dst.GenDecl{Tok: decl.Tok, Lparen: true, Rparen: true}
+				spec.Decorations().Before = dst.NewLine
+				if decl.Tok == token.CONST {
+					anchor(vs, inherited, i)
 				}
-				newDecl.Specs = append(newDecl.Specs, spec)
+			}
+			newDecl.Specs = append(newDecl.Specs, spec)
+			lastType = typ
+
+			if len(vs.Values) > 0 {
+				inherited = vs
 			}
 		}
 		results = append(results, newDecl)
+
+		for _, result := range results {
+			vc.sortSpecs(result.(*dst.GenDecl))
+		}
 	} else {
 		results = []dst.Node{decl}
 	}
@@ -60,9 +323,11 @@ func (vc *valueCleaner) walk(cursor *dstutil.Cursor) bool {
 		if d.Tok == token.CONST || d.Tok == token.VAR {
 			results := vc.separateValDecl(d)
 			cursor.Replace(results[0])
-			for _, n := range results[1:] {
-				n.Decorations().Before = dst.EmptyLine
-				cursor.InsertAfter(n)
+			// each node is inserted directly after the cursor, so the
+			// results are inserted in reverse
+			for i := len(results) - 1; i > 0; i-- {
+				results[i].Decorations().Before = dst.EmptyLine
+				cursor.InsertAfter(results[i])
 			}
 		}
 	}
@@ -74,3 +339,160 @@ func (vc *valueCleaner) walk(cursor *dstutil.Cursor) bool {
 func (vc *valueCleaner) separateValDecls() *dst.File {
 	return dstutil.Apply(vc.file, vc.walk, nil).(*dst.File)
 }
+
+// namePrefix returns the first word of a camel case or underscore
+// separated name, ie: "Err" for "ErrNotFound" and "HTTP" for
+// "HTTPTimeout".  Names of a single word have no prefix
+func namePrefix(name string) string {
+	runes := []rune(name)
+	for i := 1; i < len(runes); i++ {
+		if runes[i] == '_' {
+			return string(runes[:i])
+		}
+
+		if unicode.IsUpper(runes[i]) {
+			if unicode.IsLower(runes[i-1]) || (unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				return string(runes[:i])
+			}
+		}
+	}
+	return ""
+}
+
+// mergeKey returns the key used to decide if the declaration can be
+// merged with its neighbours.  Declarations with an explicit type are
+// merged with those of the same type while untyped declarations are
+// merged with those whose names share a prefix.  An empty key means the
+// declaration is not merged
+func mergeKey(decl *dst.GenDecl) string {
+	if (decl.Tok != token.CONST && decl.Tok != token.VAR) || decl.Lparen || len(decl.Specs) != 1 || len(decl.Decs.Tok) > 0 {
+		return ""
+	}
+
+	vs := decl.Specs[0].(*dst.ValueSpec)
+	if vs.Type != nil {
+		return decl.Tok.String() + " " + exprString(vs.Type)
+	}
+
+	if prefix := namePrefix(vs.Names[0].Name); prefix != "" {
+		return decl.Tok.String() + " " + prefix + "*"
+	}
+	return ""
+}
+
+// floating returns the index of the first comment of the decorations
+// that documents the declaration.  Comments that are separated from the
+// declaration by an empty line come before the index
+func floating(decs dst.Decorations) int {
+	for i := len(decs) - 1; i >= 0; i-- {
+		if decs[i] == "\n" {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// mergeable determines if the declaration can join a block started by
+// a previous declaration.  Declarations preceded by a floating comment
+// start a new block, as do constants that use iota since its value
+// depends on the position within the block
+func mergeable(decl *dst.GenDecl) bool {
+	vs := decl.Specs[0].(*dst.ValueSpec)
+	return floating(decl.Decs.Start) == 0 && (decl.Tok != token.CONST || !usesIota(vs))
+}
+
+// mergeValDecls merges runs of adjacent, non-parenthesized declarations
+// that share a merge key into parenthesized blocks.  The doc and line
+// comments of each declaration are moved to its spec
+func (vc *valueCleaner) mergeValDecls() *dst.File {
+	decls := []dst.Decl{}
+	run := []*dst.GenDecl{}
+	key := ""
+	flush := func() {
+		if len(run) < 2 {
+			for _, decl := range run {
+				decls = append(decls, decl)
+			}
+			run = nil
+			return
+		}
+
+		first := run[0]
+		merged := &dst.GenDecl{Tok: first.Tok, Lparen: true, Rparen: true}
+		i := floating(first.Decs.Start)
+		merged.Decs.Before = dst.EmptyLine
+		merged.Decs.Start = append(dst.Decorations{}, first.Decs.Start[:i]...)
+		first.Decs.Start = first.Decs.Start[i:]
+		merged.Decs.After = dst.EmptyLine
+		for i, decl := range run {
+			spec := decl.Specs[0]
+			decs := spec.Decorations()
+			decs.Start = append(append(dst.Decorations{}, decl.Decs.Start...), decs.Start...)
+			decs.End = append(decs.End, decl.Decs.End...)
+			decs.Before = dst.NewLine
+			if i > 0 && decl.Decs.Before == dst.EmptyLine {
+				decs.Before = dst.EmptyLine
+			}
+			decs.After = dst.NewLine
+			merged.Specs = append(merged.Specs, spec)
+		}
+		decls = append(decls, merged)
+		run = nil
+	}
+
+	for _, decl := range vc.file.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+		if !ok || mergeKey(gd) == "" {
+			flush()
+			decls = append(decls, decl)
+			continue
+		}
+
+		if mergeKey(gd) != key || !mergeable(gd) {
+			flush()
+		}
+		key = mergeKey(gd)
+		run = append(run, gd)
+	}
+	flush()
+
+	vc.file.Decls = decls
+	return vc.file
+}
+
+// hasValueBlocks determines if the file has parenthesized const or var
+// declarations with more than one spec
+func hasValueBlocks(file *dst.File) bool {
+	for _, decl := range file.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && (gd.Tok == token.CONST || gd.Tok == token.VAR) && gd.Lparen && len(gd.Specs) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// constants returns the package level constants declared in the file
+func (c *checked) constants(filename string) map[string]*types.Const {
+	consts := make(map[string]*types.Const)
+	scope := c.pkg.Scope()
+	for _, name := range scope.Names() {
+		if obj, ok := scope.Lookup(name).(*types.Const); ok && c.fset.File(obj.Pos()).Name() == filename {
+			consts[name] = obj
+		}
+	}
+	return consts
+}
+
+// sameConstant determines if the constants, which are from different
+// type checks of the package, have the same type and value
+func sameConstant(a, b *types.Const) bool {
+	if b == nil || types.TypeString(a.Type(), nil) != types.TypeString(b.Type(), nil) {
+		return false
+	}
+
+	va, vb := a.Val(), b.Val()
+	if va.Kind() != vb.Kind() {
+		return false
+	}
+	return va.Kind() == constant.Unknown || constant.Compare(va, token.EQL, vb)
+}
@@ -0,0 +1,287 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// Verification selects the checks that WriteFiles makes to ensure that
+// the transformations applied to the package did not change its meaning
+type Verification int
+
+const (
+	// VerifyNone writes the files without checking them
+	VerifyNone Verification = iota
+
+	// VerifyAPI checks that the package level declarations, their types
+	// and values and the methods of the package's types are unchanged
+	VerifyAPI
+
+	// VerifyBodies additionally checks that the source of every
+	// declaration, ignoring comments, is unchanged.  This only holds for
+	// transformations that reorder declarations, such as Organize
+	VerifyBodies
+)
+
+// packageView is the type checked form of a version of the package's
+// sources
+type packageView struct {
+	fset  *token.FileSet
+	files []*ast.File
+	pkg   *types.Package
+	errs  []error
+}
+
+// typeCheck parses and type checks the sources as a package
+func (f *Tools) typeCheck(sources map[string][]byte) *packageView {
+	view := &packageView{fset: token.NewFileSet()}
+	filenames := []string{}
+	for filename := range sources {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		file, err := parser.ParseFile(view.fset, filename, sources[filename], 0)
+		if err != nil {
+			view.errs = append(view.errs, err)
+			continue
+		}
+		view.files = append(view.files, file)
+	}
+
+	path := f.path
+	if path == "" {
+		path = f.pkgname
+	}
+
+	conf := types.Config{
+		Importer: f.importer,
+		Error:    func(err error) { view.errs = append(view.errs, err) },
+		Sizes:    f.sizes,
+	}
+	view.pkg, _ = conf.Check(path, view.fset, view.files, nil)
+	return view
+}
+
+// api describes every package level object of the package along with
+// the methods of its named types.  Descriptions do not include positions
+func (view *packageView) api() map[string]string {
+	api := make(map[string]string)
+	qualifier := types.RelativeTo(view.pkg)
+	scope := view.pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		desc := types.ObjectString(obj, qualifier)
+		if c, ok := obj.(*types.Const); ok {
+			desc += " = " + c.Val().ExactString()
+		}
+		api[name] = desc
+
+		if named, ok := obj.Type().(*types.Named); ok && obj.Name() == named.Obj().Name() {
+			for i := 0; i < named.NumMethods(); i++ {
+				method := named.Method(i)
+				api[name+"."+method.Name()] = types.ObjectString(method, qualifier)
+			}
+		}
+	}
+	return api
+}
+
+// print returns the source of the node
+func (view *packageView) print(n ast.Node) string {
+	buf := &bytes.Buffer{}
+	printer.Fprint(buf, view.fset, n)
+	return buf.String()
+}
+
+// key identifies a package level function or spec by its kind and name
+func (view *packageView) key(decl ast.Decl, spec ast.Spec) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil {
+			return fmt.Sprintf("func (%s) %s", view.print(d.Recv.List[0].Type), d.Name.Name)
+		}
+		return "func " + d.Name.Name
+	case *ast.GenDecl:
+		key := d.Tok.String()
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			key += " " + s.Name.Name
+		case *ast.ValueSpec:
+			for _, name := range s.Names {
+				key += " " + name.Name
+			}
+		}
+		return key
+	}
+	return ""
+}
+
+// declarations returns the source, without comments, of each package
+// level declaration keyed by the kind and name of the declaration.
+// Declarations that share a key, such as init functions, are combined
+func (view *packageView) declarations() map[string]string {
+	decls := make(map[string][]string)
+	for _, file := range view.files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				src := view.print(d.Type)
+				if d.Body != nil {
+					src += " " + view.print(d.Body)
+				}
+				decls[view.key(d, nil)] = append(decls[view.key(d, nil)], src)
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if _, ok := spec.(*ast.ImportSpec); !ok {
+						key := view.key(d, spec)
+						decls[key] = append(decls[key], view.print(spec))
+					}
+				}
+			}
+		}
+	}
+
+	combined := make(map[string]string)
+	for key, sources := range decls {
+		sort.Strings(sources)
+		combined[key] = strings.Join(sources, "\n")
+	}
+	return combined
+}
+
+// errorKey identifies the error by its message and the declaration it
+// is in, neither of which change when declarations are moved
+func (view *packageView) errorKey(err error) string {
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		msgs := []string{}
+		for _, e := range list {
+			msgs = append(msgs, e.Msg)
+		}
+		return strings.Join(msgs, "\n")
+	}
+
+	terr, ok := err.(types.Error)
+	if !ok {
+		return err.Error()
+	}
+
+	for _, file := range view.files {
+		for _, decl := range file.Decls {
+			if terr.Pos < decl.Pos() || terr.Pos >= decl.End() {
+				continue
+			}
+
+			if d, ok := decl.(*ast.GenDecl); ok {
+				for _, spec := range d.Specs {
+					if terr.Pos >= spec.Pos() && terr.Pos < spec.End() {
+						return terr.Msg + " in " + view.key(d, spec)
+					}
+				}
+			}
+			return terr.Msg + " in " + view.key(decl, nil)
+		}
+	}
+	return terr.Msg
+}
+
+// compare returns the first key, in sorted order, whose value differs
+// between the maps, or an empty string if they are the same
+func compare(before, after map[string]string) string {
+	keys := []string{}
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, found := before[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b, inBefore := before[key]
+		a, inAfter := after[key]
+		if inBefore != inAfter || a != b {
+			return key
+		}
+	}
+	return ""
+}
+
+// describe returns a description of how the value of key changed
+func describe(key string, before, after map[string]string) string {
+	b, inBefore := before[key]
+	a, inAfter := after[key]
+	switch {
+	case !inAfter:
+		return b + " removed"
+	case !inBefore:
+		return a + " added"
+	}
+	return b + " changed to " + a
+}
+
+// originals returns the content of every file in the set as it was
+// before any changes were recorded
+func (f *Tools) originals() map[string][]byte {
+	sources := make(map[string][]byte)
+	for filename := range f.dfiles {
+		if change, found := f.changed[filename]; found {
+			sources[filename] = change.Orig
+		} else {
+			sources[filename] = f.print(filename)
+		}
+	}
+	return sources
+}
+
+// Verify type checks the package as it was when the files were added
+// and as it is now and returns an ErrNotEquivalent error if the changes
+// made anything other than the position of declarations differ or
+// introduced a type error.  The checks made are selected by
+// SetVerification and, if verification is disabled, VerifyAPI is used
+func (f *Tools) Verify() error {
+	level := f.verify
+	if level == VerifyNone {
+		level = VerifyAPI
+	}
+
+	before := f.typeCheck(f.originals())
+	after := f.typeCheck(f.snapshot())
+	errs := make(map[string]int)
+	for _, err := range before.errs {
+		errs[before.errorKey(err)]++
+	}
+
+	for _, err := range after.errs {
+		key := after.errorKey(err)
+		if errs[key] == 0 {
+			return fmt.Errorf("%w: %v", ErrNotEquivalent, err)
+		}
+		errs[key]--
+	}
+
+	beforeAPI, afterAPI := before.api(), after.api()
+	if key := compare(beforeAPI, afterAPI); key != "" {
+		return fmt.Errorf("%w: %s", ErrNotEquivalent, describe(key, beforeAPI, afterAPI))
+	}
+
+	if level == VerifyBodies {
+		if key := compare(before.declarations(), after.declarations()); key != "" {
+			return fmt.Errorf("%w: %s changed", ErrNotEquivalent, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	input := `package foo
+
+func helper() int { return 1 }
+
+type Foo struct{}
+
+func (f *Foo) Bar() int { return helper() }
+
+const (
+	A int = iota
+	B
+	S string = "s"
+)
+
+var unused = 1
+
+type point struct{ X, Y int }
+
+func origin() point { return point{0, 0} }
+`
+
+	tests := []struct {
+		name         string
+		verification Verification
+		op           func(*Tools) error
+		wantErr      error
+	}{
+		{
+			name:         "organize",
+			verification: VerifyBodies,
+			op:           func(tools *Tools) error { return tools.OrganizeAll() },
+		},
+		{
+			name:         "separate values",
+			verification: VerifyAPI,
+			op: func(tools *Tools) error {
+				_, err := tools.SeparateValues("foo.go")
+				return err
+			},
+		},
+		{
+			name:         "dead code",
+			verification: VerifyAPI,
+			op: func(tools *Tools) error {
+				_, err := tools.DeadCode(true)
+				return err
+			},
+			wantErr: ErrNotEquivalent,
+		},
+		{
+			name:         "body changed",
+			verification: VerifyBodies,
+			op:           func(tools *Tools) error { return tools.KeyLiterals() },
+			wantErr:      ErrNotEquivalent,
+		},
+		{
+			name:         "api unchanged",
+			verification: VerifyAPI,
+			op:           func(tools *Tools) error { return tools.KeyLiterals() },
+		},
+		{
+			name:         "disabled",
+			verification: VerifyNone,
+			op: func(tools *Tools) error {
+				_, err := tools.DeadCode(true)
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tools := New()
+			tools.SetVerification(test.verification)
+			if err := tools.Add("foo.go", []byte(input)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if err := test.op(tools); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(tools.ChangedFiles()) == 0 {
+				t.Fatalf("Expected the operation to change the file")
+			}
+
+			written := []string{}
+			err := tools.WriteFiles(func(filename string, data []byte) error {
+				written = append(written, filename)
+				return nil
+			})
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Wanted error %v got %v", test.wantErr, err)
+			}
+
+			if test.wantErr != nil && len(written) > 0 {
+				t.Errorf("Expected no files to be written, got %v", written)
+			}
+		})
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	input := `package foo
+
+func a() int { return "a" }
+
+func b() int { return 1 }
+`
+
+	tests := []struct {
+		name    string
+		output  string
+		wantErr error
+	}{
+		{
+			name: "moved error",
+			output: `package foo
+
+func b() int { return 1 }
+
+func a() int { return "a" }
+`,
+		},
+		{
+			name: "replaced error",
+			output: `package foo
+
+func a() int { return 1 }
+
+func b() int { return "a" }
+`,
+			wantErr: ErrNotEquivalent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tools := New()
+			if err := tools.Add("foo.go", []byte(input)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			start := tools.print("foo.go")
+			if err := tools.parse("foo.go", []byte(test.output)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if _, err := tools.record("foo.go", start); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if err := tools.Verify(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Wanted error %v got %v", test.wantErr, err)
+			}
+		})
+	}
+}
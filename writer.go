@@ -0,0 +1,175 @@
+package tools
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// staged is a file whose new content has been written to a temporary
+// file next to it
+type staged struct {
+	filename string
+	temp     string
+	backup   string
+	existed  bool
+}
+
+// AtomicWriter stages files in temporary files next to their targets
+// and, on Commit, renames them into place.  The mode and, where the
+// platform supports it, the owner of existing files are preserved.  If
+// any file can not be replaced the files that already were are restored
+// so that either every file in the batch is written or none are.  Its
+// Write method can be used as a FileWriter
+type AtomicWriter struct {
+	// Backup keeps the original content of each replaced file in a file
+	// of the same name with a .orig suffix
+	Backup bool
+
+	staged []*staged
+}
+
+// NewAtomicWriter creates an AtomicWriter that, if backup is true, keeps
+// the original content of the files it replaces
+func NewAtomicWriter(backup bool) *AtomicWriter {
+	return &AtomicWriter{Backup: backup}
+}
+
+// Write stages the data to be written to filename by Commit
+func (w *AtomicWriter) Write(filename string, data []byte) (err error) {
+	s := &staged{filename: filename}
+	mode := os.FileMode(0644)
+	fi, err := os.Stat(filename)
+	if err == nil {
+		s.existed = true
+		mode = fi.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	s.temp = temp.Name()
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(s.temp, mode)
+	}
+
+	if err == nil && s.existed {
+		err = chown(s.temp, fi)
+	}
+
+	if err != nil {
+		os.Remove(s.temp)
+		return err
+	}
+
+	w.staged = append(w.staged, s)
+	return nil
+}
+
+// link makes backup refer to the content of filename, copying the
+// content if the file system does not support hard links
+func link(filename, backup string) error {
+	os.Remove(backup)
+	if os.Link(filename, backup) == nil {
+		return nil
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Commit replaces every staged file.  If a file can not be replaced the
+// files replaced before it are restored, the remaining temporary files
+// are removed and the error is returned
+func (w *AtomicWriter) Commit() (err error) {
+	done := []*staged{}
+	for _, s := range w.staged {
+		if s.existed {
+			s.backup = s.filename + ".orig"
+			if !w.Backup {
+				s.backup = s.temp + ".orig"
+			}
+			err = link(s.filename, s.backup)
+		}
+
+		if err == nil {
+			err = os.Rename(s.temp, s.filename)
+		}
+
+		if err != nil {
+			if s.backup != "" && !w.Backup {
+				os.Remove(s.backup)
+			}
+			break
+		}
+		done = append(done, s)
+	}
+
+	if err != nil {
+		for _, s := range done {
+			if s.existed {
+				os.Rename(s.backup, s.filename)
+			} else {
+				os.Remove(s.filename)
+			}
+		}
+		w.Abort()
+		return err
+	}
+
+	for _, s := range done {
+		if s.existed && !w.Backup {
+			os.Remove(s.backup)
+		}
+	}
+	w.staged = nil
+	return nil
+}
+
+// Abort removes the staged temporary files without replacing any file
+func (w *AtomicWriter) Abort() {
+	for _, s := range w.staged {
+		os.Remove(s.temp)
+	}
+	w.staged = nil
+}
+
+// WriteFilesAtomic writes the changed files with an AtomicWriter so that
+// either every changed file is replaced or none are.  If backup is true
+// the original content of each file is kept in a .orig file
+func (f *Tools) WriteFilesAtomic(backup bool) error {
+	w := NewAtomicWriter(backup)
+	err := f.WriteFiles(w.Write)
+	if err == nil {
+		err = w.Commit()
+	} else {
+		w.Abort()
+	}
+	return err
+}
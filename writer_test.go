@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicWriter(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.go")
+	created := filepath.Join(dir, "created.go")
+	if err := ioutil.WriteFile(existing, []byte("old"), 0640); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	w := NewAtomicWriter(true)
+	for _, filename := range []string{existing, created} {
+		if err := w.Write(filename, []byte("new")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// nothing is replaced until the batch is committed
+	if content, _ := ioutil.ReadFile(existing); string(content) != "old" {
+		t.Errorf("Wanted %q got %q", "old", string(content))
+	}
+
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for filename, want := range map[string]string{existing: "new", created: "new", existing + ".orig": "old"} {
+		if content, err := ioutil.ReadFile(filename); err != nil || string(content) != want {
+			t.Errorf("%s: wanted %q got %q (%v)", filepath.Base(filename), want, string(content), err)
+		}
+	}
+
+	if fi, err := os.Stat(existing); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("Wanted mode %v got %v (%v)", os.FileMode(0640), fi.Mode().Perm(), err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Wanted 3 files got %d", len(entries))
+	}
+}
+
+func TestAtomicWriterFailure(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.go")
+	created := filepath.Join(dir, "created.go")
+	blocked := filepath.Join(dir, "blocked.go")
+	if err := ioutil.WriteFile(first, []byte("old"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// a directory can not be replaced by a file
+	if err := os.MkdirAll(filepath.Join(blocked, "sub"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	w := NewAtomicWriter(false)
+	for _, filename := range []string{first, created, blocked} {
+		if err := w.Write(filename, []byte("new")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err := w.Commit(); err == nil {
+		t.Fatalf("Expected an error")
+	}
+
+	if content, _ := ioutil.ReadFile(first); string(content) != "old" {
+		t.Errorf("Wanted %q got %q", "old", string(content))
+	}
+
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed", created)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("Wanted 2 entries got %v", names)
+	}
+}